@@ -2,19 +2,22 @@ module github.com/fleshin/flechade
 
 go 1.20
 
-require github.com/theckman/yacspin v0.13.12
+require (
+	github.com/godbus/dbus/v5 v5.1.0
+	github.com/hashicorp/go-version v1.6.0
+	github.com/theckman/yacspin v0.13.12
+	golang.org/x/crypto v0.13.0
+)
 
 require (
 	github.com/Microsoft/go-winio v0.6.1 // indirect
 	github.com/emirpasic/gods v1.18.1 // indirect
-	github.com/hashicorp/go-version v1.6.0 // indirect
 	github.com/jbenet/go-context v0.0.0-20150711004518-d14ea06fba99 // indirect
 	github.com/kevinburke/ssh_config v1.2.0 // indirect
 	github.com/mitchellh/go-homedir v1.1.0 // indirect
 	github.com/sergi/go-diff v1.3.1 // indirect
 	github.com/src-d/gcfg v1.4.0 // indirect
 	github.com/xanzy/ssh-agent v0.3.3 // indirect
-	golang.org/x/crypto v0.13.0 // indirect
 	golang.org/x/mod v0.12.0 // indirect
 	golang.org/x/net v0.15.0 // indirect
 	golang.org/x/tools v0.13.0 // indirect
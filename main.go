@@ -3,6 +3,8 @@ package main
 import (
 	"embed"
 	"flag"
+
+	"github.com/fleshin/flechade/run"
 )
 
 //go:embed data/*
@@ -10,15 +12,46 @@ var configFS embed.FS
 
 func main() {
 
-	showVersion()
-
 	dataDir := flag.String("d", "", "Load customizations from local directory")
-	repoUrl := flag.String("r", "", "Load customizations from GIT repository")
+	repoUrl := flag.String("r", "", "Load customizations from GIT repository (repo#ref:subdir)")
+	keyFile := flag.String("i", "", "SSH private key used to clone the GIT repository")
+	token := flag.String("t", "", "Access token used to clone the GIT repository (or FLECHADE_GIT_TOKEN)")
 	runSet := flag.Bool("l", false, "Run default customizations")
+	requireSig := flag.Bool("require-signature", false, "Refuse to run unsigned or tampered customization sets")
+	trustStore := flag.String("trust", run.TrustStore, "File with the public keys allowed to sign customization sets")
+	plan := flag.Bool("n", false, "Show the steps and the changes they would make without applying them")
+	targetUser := flag.String("user", "", "User to customize (defaults to the user running sudo)")
+	rootDir := flag.String("root", "", "Apply customizations to the root filesystem mounted at this directory")
 	cont := flag.Bool("c", false, "Continue previous execution from the last successful step")
 
 	flag.Parse()
 
+	run.SignatureRequired = *requireSig
+	run.TrustStore = *trustStore
+	run.RootDir = *rootDir
+	run.TargetUser = *targetUser
+	run.PlanMode = *plan
+
+	// plumbing commands whose output is read by other programs
+	switch flag.Arg(0) {
+	case "capture":
+		captureSet(flag.Args()[1:])
+		return
+	case "shell-ext":
+		callShellExt(flag.Args()[1:])
+		return
+	case "manifest":
+		writeManifest(flag.Args()[1:])
+		return
+	}
+
+	showVersion()
+
+	if flag.Arg(0) == "apply" {
+		runApply(flag.Args()[1:])
+		return
+	}
+
 	switch {
 	case *cont:
 		contPrevRun()
@@ -27,7 +60,7 @@ func main() {
 	case *dataDir != "":
 		runFromDir(*dataDir)
 	case *repoUrl != "":
-		runFromUrl(*repoUrl)
+		runFromUrl(*repoUrl, *keyFile, *token)
 	default:
 		flag.Usage()
 	}
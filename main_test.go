@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// TestMain lets the tests run the test binary as flechade itself
+func TestMain(m *testing.M) {
+	if os.Getenv("FLECHADE_TEST_MAIN") == "1" {
+		shellExtCall = func(method string, args ...string) (string, error) {
+			return "true", nil
+		}
+		os.Args = append([]string{"flechade"}, os.Args[1:]...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestShellExtOutput(t *testing.T) {
+	cmd := exec.Command(os.Args[0], "shell-ext", "EnableExtension", "dash-to-dock@micxgx.gmail.com")
+	cmd.Env = append(os.Environ(), "FLECHADE_TEST_MAIN=1")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "true\n" {
+		t.Errorf("shell-ext printed %q, want the bare reply %q", out, "true\n")
+	}
+}
@@ -2,15 +2,21 @@ package main
 
 import (
 	"embed"
+	"flag"
 	"fmt"
 	"io"
 	"io/fs"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/fleshin/flechade/run"
 	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	gitssh "gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
 )
 
 func showVersion() {
@@ -59,22 +65,187 @@ func runFromDir(dataDir string) {
 		log.Fatal(err)
 	}
 	set.Run()
-	fmt.Println("Setup complete. Enjoy!")
+	if !run.PlanMode {
+		fmt.Println("Setup complete. Enjoy!")
+	}
+}
+
+// repoSource describes a set stored in a GIT repository using the
+// repo#ref:subdir syntax. Ref and subdir are both optional.
+type repoSource struct {
+	URL    string
+	Ref    string
+	SubDir string
+}
+
+func parseRepoSource(spec string) repoSource {
+	var src repoSource
+	src.URL = spec
+	hash := strings.LastIndex(spec, "#")
+	if hash < 0 {
+		return src
+	}
+	src.URL = spec[:hash]
+	frag := spec[hash+1:]
+	colon := strings.Index(frag, ":")
+	if colon < 0 {
+		src.Ref = frag
+		return src
+	}
+	src.Ref = frag[:colon]
+	src.SubDir = strings.Trim(frag[colon+1:], "/")
+	return src
+}
+
+func isSSHUrl(repoUrl string) bool {
+	if strings.HasPrefix(repoUrl, "ssh://") {
+		return true
+	}
+	// scp-like syntax: user@host:path
+	return !strings.Contains(repoUrl, "://") && strings.Contains(repoUrl, "@") && strings.Contains(repoUrl, ":")
+}
+
+func repoAuth(repoUrl string, keyFile string, token string) (transport.AuthMethod, error) {
+	if token == "" {
+		token = os.Getenv("FLECHADE_GIT_TOKEN")
+	}
+	if !isSSHUrl(repoUrl) {
+		if token == "" {
+			return nil, nil
+		}
+		return &githttp.BasicAuth{Username: "flechade", Password: token}, nil
+	}
+	user := "git"
+	if at := strings.Index(strings.TrimPrefix(repoUrl, "ssh://"), "@"); at > 0 {
+		user = strings.TrimPrefix(repoUrl, "ssh://")[:at]
+	}
+	if keyFile != "" {
+		return gitssh.NewPublicKeysFromFile(user, keyFile, os.Getenv("FLECHADE_SSH_PASSPHRASE"))
+	}
+	return gitssh.NewSSHAgentAuth(user)
 }
 
-func runFromUrl(repoUrl string) {
-	tgtDir := "/tmp/flechade-repo"
+func checkoutRef(repo *git.Repository, ref string) error {
+	hash, err := repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		hash, err = repo.ResolveRevision(plumbing.Revision("origin/" + ref))
+	}
+	if err != nil {
+		return fmt.Errorf("unable to resolve reference %s: %w", ref, err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	return wt.Checkout(&git.CheckoutOptions{Hash: *hash, Force: true})
+}
+
+func cloneSet(spec string, tgtDir string, keyFile string, token string) (string, error) {
+	src := parseRepoSource(spec)
 	err := os.RemoveAll(tgtDir)
 	if err != nil {
-		log.Fatal(err)
+		return "", err
+	}
+	auth, err := repoAuth(src.URL, keyFile, token)
+	if err != nil {
+		return "", err
 	}
-	_, err = git.PlainClone(tgtDir, false, &git.CloneOptions{
-		URL: repoUrl,
+	repo, err := git.PlainClone(tgtDir, false, &git.CloneOptions{
+		URL:  src.URL,
+		Auth: auth,
+		Tags: git.AllTags,
 	})
+	if err != nil {
+		return "", err
+	}
+	if src.Ref != "" {
+		err = checkoutRef(repo, src.Ref)
+		if err != nil {
+			return "", err
+		}
+	}
+	setDir := tgtDir
+	if src.SubDir != "" {
+		setDir = filepath.Join(tgtDir, filepath.Clean("/"+src.SubDir))
+	}
+	return setDir, nil
+}
+
+func runFromArchive(src string, checksum string) {
+	set, err := run.LoadSetFromArchive(src, checksum)
+	if err != nil {
+		log.Fatal(err)
+	}
+	set.Run()
+	if !run.PlanMode {
+		fmt.Println("Setup complete. Enjoy!")
+	}
+}
+
+func runApply(args []string) {
+	applyFlags := flag.NewFlagSet("apply", flag.ExitOnError)
+	checksum := applyFlags.String("sha256", "", "Expected sha256 checksum of the archive")
+	applyFlags.Parse(args)
+	if applyFlags.NArg() != 1 {
+		fmt.Println("usage: flechade apply [-sha256 sum] <dir|archive|url>")
+		os.Exit(2)
+	}
+	src := applyFlags.Arg(0)
+	switch {
+	case run.IsArchive(src):
+		runFromArchive(src, *checksum)
+	default:
+		runFromDir(src)
+	}
+}
+
+func captureSet(args []string) {
+	if len(args) != 1 {
+		fmt.Println("usage: flechade capture <dir>")
+		os.Exit(2)
+	}
+	err := run.Capture(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Set captured into", args[0])
+}
+
+// shellExtCall talks to GNOME Shell, replaced in tests
+var shellExtCall = run.ShellExtCall
+
+// callShellExt runs as the target user on behalf of steps talking to
+// GNOME Shell, see run.ShellExtCall
+func callShellExt(args []string) {
+	if len(args) < 1 {
+		os.Exit(2)
+	}
+	out, err := shellExtCall(args[0], args[1:]...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Println(out)
+}
+
+func writeManifest(args []string) {
+	if len(args) != 1 {
+		fmt.Println("usage: flechade manifest <dir>")
+		os.Exit(2)
+	}
+	err := run.WriteManifest(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Manifest written. Sign it with: ssh-keygen -Y sign -f <key> -n flechade flechade.sums")
+}
+
+func runFromUrl(repoUrl string, keyFile string, token string) {
+	setDir, err := cloneSet(repoUrl, "/tmp/flechade-repo", keyFile, token)
 	if err != nil {
 		log.Fatal(err)
 	}
-	runFromDir(tgtDir)
+	runFromDir(setDir)
 }
 
 func contPrevRun() {
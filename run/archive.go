@@ -0,0 +1,158 @@
+package run
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// IsArchive reports whether src points to a set packed as .tar.gz, .tgz or .zip
+func IsArchive(src string) bool {
+	name := strings.ToLower(src)
+	if strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://") {
+		name = strings.SplitN(name, "?", 2)[0]
+	}
+	return strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz") || strings.HasSuffix(name, ".zip")
+}
+
+// LoadSetFromArchive reads a set from a local or remote archive, keeping its
+// files in memory. An optional sha256 checksum (hex, may be prefixed with
+// "sha256:") is verified before the archive is opened.
+func LoadSetFromArchive(src string, checksum string) (*Set, error) {
+	var s Set
+	var err error
+	s.Root = RootDir
+	s.Archive = src
+	s.files, s.Checksum, err = openArchive(src, checksum)
+	if err != nil {
+		return &s, err
+	}
+	err = s.loadFiles()
+	return &s, err
+}
+
+// openArchive unpacks the set archive at src, returning its files and the
+// checksum it was verified against. Without a checksum the one of the
+// archive read is returned, so a later run opens the same archive.
+func openArchive(src string, checksum string) (fs.FS, string, error) {
+	data, err := fetchArchive(src)
+	if err != nil {
+		return nil, "", err
+	}
+	if checksum != "" {
+		err = verifyChecksum(src, data, checksum)
+		if err != nil {
+			return nil, "", err
+		}
+	} else {
+		sum := sha256.Sum256(data)
+		checksum = "sha256:" + hex.EncodeToString(sum[:])
+	}
+	var files fs.FS
+	if strings.HasSuffix(strings.ToLower(strings.SplitN(src, "?", 2)[0]), ".zip") {
+		files, err = zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	} else {
+		files, err = readTarGz(data)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	files, err = setRoot(files)
+	return files, checksum, err
+}
+
+// verifyChecksum compares data with a sha256 checksum in hex, which may be
+// prefixed with "sha256:"
+func verifyChecksum(src string, data []byte, checksum string) error {
+	sum := sha256.Sum256(data)
+	want := strings.ToLower(strings.TrimPrefix(checksum, "sha256:"))
+	if hex.EncodeToString(sum[:]) != want {
+		return fmt.Errorf("checksum mismatch for %s: got %x", src, sum)
+	}
+	return nil
+}
+
+// httpClient fetches archives and queries web services, giving up on
+// servers that stop answering
+var httpClient = &http.Client{Timeout: 10 * time.Minute}
+
+func fetchArchive(src string) ([]byte, error) {
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		return os.ReadFile(src)
+	}
+	resp, err := httpClient.Get(src)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to download %s: %s", src, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func readTarGz(data []byte) (fs.FS, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	files := memFS{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		if name == "" {
+			continue
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			files[name] = &memFile{Mode: fs.ModeDir | fs.FileMode(hdr.Mode).Perm()}
+		case tar.TypeReg:
+			content, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+			files[name] = &memFile{Data: content, Mode: fs.FileMode(hdr.Mode).Perm(), ModTime: hdr.ModTime}
+		}
+	}
+	return files, nil
+}
+
+// setRoot descends into a single top level directory when the archive was
+// packed with one (set-1.0/flechade.yaml)
+func setRoot(files fs.FS) (fs.FS, error) {
+	if _, err := fs.Stat(files, "flechade.yaml"); err == nil {
+		return files, nil
+	}
+	entries, err := fs.ReadDir(files, ".")
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 1 && entries[0].IsDir() {
+		sub, err := fs.Sub(files, entries[0].Name())
+		if err != nil {
+			return nil, err
+		}
+		return setRoot(sub)
+	}
+	return nil, errors.New("flechade.yaml not found in archive")
+}
@@ -0,0 +1,156 @@
+package run
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type managedBlock struct {
+	File   string
+	Id     string
+	Prefix string
+}
+
+// blockParams reads the destination, block id and comment prefix of an
+// AppendFile step: cfg dst [id] [prefix]
+func blockParams(param []string) managedBlock {
+	b := managedBlock{File: param[1], Id: filepath.Base(param[0]), Prefix: "#"}
+	if len(param) > 2 && param[2] != "" {
+		b.Id = param[2]
+	}
+	if len(param) > 3 && param[3] != "" {
+		b.Prefix = param[3]
+	}
+	return b
+}
+
+func (b managedBlock) markers() (string, string) {
+	return b.Prefix + " flechade BEGIN " + b.Id, b.Prefix + " flechade END " + b.Id
+}
+
+// findBlock returns the lines of the first block between the begin and
+// end markers, -1 when there is none
+func findBlock(lines []string, begin string, end string) (int, int) {
+	start := -1
+	for i, line := range lines {
+		switch strings.TrimSpace(line) {
+		case begin:
+			if start < 0 {
+				start = i
+			}
+		case end:
+			if start >= 0 {
+				return start, i
+			}
+		}
+	}
+	return -1, -1
+}
+
+// setBlock replaces the managed block in lines with content, appending it
+// when missing. A nil content removes the block.
+func setBlock(lines []string, b managedBlock, content []string) []string {
+	begin, end := b.markers()
+	start, stop := findBlock(lines, begin, end)
+	var block []string
+	if content != nil {
+		block = append(append([]string{begin}, content...), end)
+	}
+	if start < 0 {
+		return append(lines, block...)
+	}
+	result := append([]string{}, lines[:start]...)
+	result = append(result, block...)
+	return append(result, lines[stop+1:]...)
+}
+
+// adoptLegacyBlock gives the first block written by older versions
+// (START/END without id) the markers of b, so the AppendFile step that
+// wrote it updates it instead of appending a second copy
+func adoptLegacyBlock(lines []string, b managedBlock) []string {
+	begin, end := b.markers()
+	if start, _ := findBlock(lines, begin, end); start >= 0 {
+		return lines
+	}
+	start, stop := findBlock(lines, b.Prefix+" flechade START", b.Prefix+" flechade END")
+	if start < 0 {
+		return lines
+	}
+	result := append([]string{}, lines...)
+	result[start], result[stop] = begin, end
+	return result
+}
+
+func execAppendFile(s *Set, param ...string) (string, error) {
+	cfg := param[0]
+	b := blockParams(param)
+
+	data, err := fs.ReadFile(s.files, cfg)
+	if err != nil {
+		return "", err
+	}
+	content := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	changed, err := s.editLines(b.File, true, func(lines []string) []string {
+		return setBlock(adoptLegacyBlock(lines, b), b, content)
+	})
+	return editReport(changed, "updated block %s in %s", b.Id, b.File), err
+}
+
+func execRemoveBlock(s *Set, param ...string) (string, error) {
+	b := managedBlock{File: param[0], Id: param[1], Prefix: "#"}
+	if len(param) > 2 && param[2] != "" {
+		b.Prefix = param[2]
+	}
+	if _, err := os.Stat(s.path(b.File)); os.IsNotExist(err) {
+		return editReport(false, "removed block %s from %s", b.Id, b.File), nil
+	}
+	changed, err := s.editLines(b.File, false, func(lines []string) []string {
+		return setBlock(lines, b, nil)
+	})
+	return editReport(changed, "removed block %s from %s", b.Id, b.File), err
+}
+
+func (s *Set) declaredBlocks() []managedBlock {
+	var blocks []managedBlock
+	for _, stp := range s.Steps {
+		if stp.Command == "AppendFile" && len(stp.Params) >= 2 {
+			blocks = append(blocks, blockParams(stp.Params))
+		}
+	}
+	return blocks
+}
+
+// pruneBlocks removes the blocks appended by the previous run of the same
+// set whose steps are gone from the current one
+func (s *Set) pruneBlocks() error {
+	file, err := os.Open(s.configFile)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	var prev Set
+	if json.NewDecoder(file).Decode(&prev) != nil || prev.Name != s.Name {
+		return nil
+	}
+	current := s.declaredBlocks()
+	for _, b := range prev.declaredBlocks() {
+		kept := false
+		for _, c := range current {
+			if c == b {
+				kept = true
+			}
+		}
+		if kept {
+			continue
+		}
+		_, err := execRemoveBlock(s, b.File, b.Id, b.Prefix)
+		if err != nil {
+			return fmt.Errorf("unable to remove block %s from %s: %w", b.Id, b.File, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,370 @@
+package run
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var signedByRe = regexp.MustCompile(`(?i)signed-by[=:]\s*([^\s\]]+)`)
+
+var sourceHostRe = regexp.MustCompile(`[a-z+]+://([^/\s\]]+)`)
+
+// distroDomains are the archive domains of the distributions named by
+// ID and ID_LIKE in os-release
+var distroDomains = map[string]string{
+	"debian":     "debian.org",
+	"ubuntu":     "ubuntu.com",
+	"linuxmint":  "linuxmint.com",
+	"pop":        "pop-os.org",
+	"elementary": "elementary.io",
+	"kali":       "kali.org",
+	"raspbian":   "raspbian.org",
+}
+
+// Capture inspects the current system and writes a set reproducing it into
+// dir: flechade.yaml plus every file referenced by its steps
+func Capture(dir string) error {
+	var s Set
+	s.Root = RootDir
+	s.Ver = GetVer()
+	s.Name = "captured"
+	s.Description = "Customizations captured from " + hostname()
+	err := s.loadEnv()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	err = s.captureAptSources(dir)
+	if err != nil {
+		return err
+	}
+	s.capturePackages()
+	s.captureFlatpaks()
+	s.captureGnomeExtensions()
+	err = s.captureDconf(dir)
+	if err != nil {
+		return err
+	}
+	s.captureShell()
+	return s.writeYaml(filepath.Join(dir, "flechade.yaml"))
+}
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "unknown host"
+	}
+	return name
+}
+
+func (s *Set) addCaptured(desc string, cmdId string, args ...string) {
+	s.Steps = append(s.Steps, step{Command: cmdId, Params: args, Desc: desc})
+}
+
+func (s *Set) writeYaml(file string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	encoder := yaml.NewEncoder(f)
+	encoder.SetIndent(2)
+	err = encoder.Encode(s)
+	if err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+func (s *Set) copyToSet(src string, dir string) (string, error) {
+	data, err := os.ReadFile(s.path(src))
+	if err != nil {
+		return "", err
+	}
+	name := filepath.Base(src)
+	err = os.WriteFile(filepath.Join(dir, name), data, 0644)
+	return name, err
+}
+
+// distroIds returns ID and ID_LIKE of os-release
+func (s *Set) distroIds() []string {
+	data, err := os.ReadFile(s.path("/etc/os-release"))
+	if err != nil {
+		return nil
+	}
+	var ids []string
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, found := strings.Cut(strings.TrimSpace(line), "=")
+		if found && (key == "ID" || key == "ID_LIKE") {
+			ids = append(ids, strings.Fields(strings.ToLower(strings.Trim(value, `"'`)))...)
+		}
+	}
+	return ids
+}
+
+// distroHosts lists the hosts serving the distribution itself: the
+// archive domains of its os-release ids and the mirrors whose Release
+// files carry one of these ids as Origin
+func (s *Set) distroHosts() []string {
+	ids := s.distroIds()
+	var hosts []string
+	for _, id := range ids {
+		if domain, ok := distroDomains[id]; ok {
+			hosts = append(hosts, domain)
+		}
+	}
+	releases, _ := filepath.Glob(s.path("/var/lib/apt/lists/*Release"))
+	for _, release := range releases {
+		data, err := os.ReadFile(release)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			origin, found := strings.CutPrefix(line, "Origin:")
+			if !found {
+				continue
+			}
+			if containsStr(ids, strings.ToLower(strings.TrimSpace(origin))) {
+				// lists are named after the URL: deb.debian.org_debian_dists_..._InRelease
+				host, _, _ := strings.Cut(filepath.Base(release), "_")
+				hosts = append(hosts, host)
+			}
+			break
+		}
+	}
+	return hosts
+}
+
+// isDistroSource tells whether every repository of a sources file is
+// served by the distribution
+func isDistroSource(data string, hosts []string) bool {
+	var matches [][]string
+	for _, line := range strings.Split(data, "\n") {
+		if !isComment(line) {
+			matches = append(matches, sourceHostRe.FindAllStringSubmatch(line, -1)...)
+		}
+	}
+	if len(matches) == 0 {
+		return false
+	}
+	for _, m := range matches {
+		host := strings.ToLower(m[1])
+		if i := strings.LastIndex(host, "@"); i >= 0 {
+			host = host[i+1:]
+		}
+		host, _, _ = strings.Cut(host, ":")
+		ok := false
+		for _, h := range hosts {
+			if host == h || strings.HasSuffix(host, "."+h) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Set) captureAptSources(dir string) error {
+	sources, err := filepath.Glob(s.path("/etc/apt/sources.list.d/*"))
+	if err != nil {
+		return err
+	}
+	hosts := s.distroHosts()
+	keys := make(map[string]bool)
+	var sourceSteps []step
+	for _, src := range sources {
+		if !strings.HasSuffix(src, ".list") && !strings.HasSuffix(src, ".sources") {
+			continue
+		}
+		data, err := os.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		if isDistroSource(string(data), hosts) {
+			// distribution sources are not third party
+			continue
+		}
+		src = strings.TrimPrefix(src, s.Root)
+		name, err := s.copyToSet(src, dir)
+		if err != nil {
+			return err
+		}
+		sourceSteps = append(sourceSteps, step{
+			Command: "CopyFile",
+			Params:  []string{name, "/etc/apt/sources.list.d"},
+			Desc:    "Installing " + name + " sources",
+		})
+		for _, m := range signedByRe.FindAllStringSubmatch(string(data), -1) {
+			keys[m[1]] = true
+		}
+	}
+	if len(sourceSteps) == 0 {
+		return nil
+	}
+	keyFiles := make([]string, 0, len(keys))
+	for key := range keys {
+		keyFiles = append(keyFiles, key)
+	}
+	sort.Strings(keyFiles)
+	for _, key := range keyFiles {
+		name, err := s.copyToSet(key, dir)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		s.addCaptured("Installing "+name+" repository key", "CopyFile", name, filepath.Dir(key))
+	}
+	s.Steps = append(s.Steps, sourceSteps...)
+	s.addCaptured("Updating package repositories", "UpdateRepos")
+	return nil
+}
+
+func (s *Set) capturePackages() {
+	out, err := s.command("apt-mark", "showmanual").Output()
+	if err != nil {
+		return
+	}
+	// leave out the base system installed by debian-installer
+	base := make(map[string]bool)
+	prio, _ := s.command("dpkg-query", "-W", "-f", "${Package} ${Priority}\n").Output()
+	for _, line := range strings.Split(string(prio), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && (fields[1] == "required" || fields[1] == "important" || fields[1] == "standard") {
+			base[fields[0]] = true
+		}
+	}
+	var pkgs []string
+	for _, pkg := range strings.Fields(string(out)) {
+		if !base[pkg] {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	if len(pkgs) > 0 {
+		s.addCaptured("Installing packages", "InstallPackages", strings.Join(pkgs, " "))
+	}
+}
+
+func (s *Set) captureFlatpaks() {
+	out, err := s.command("flatpak", "list", "--app", "--columns=application").Output()
+	if err != nil {
+		return
+	}
+	seen := make(map[string]bool)
+	var apps []string
+	for _, app := range strings.Fields(string(out)) {
+		if !seen[app] {
+			seen[app] = true
+			apps = append(apps, app)
+		}
+	}
+	if len(apps) == 0 {
+		return
+	}
+	s.addCaptured("Enabling Flatpaks", "EnableFlatpak")
+	s.addCaptured("Installing Flatpak apps", "InstallFlatpaks", strings.Join(apps, " "))
+}
+
+func (s *Set) captureGnomeExtensions() {
+	enabled := make(map[string]bool)
+	out, err := s.userDconf("read", "/org/gnome/shell/enabled-extensions").Output()
+	known := err == nil
+	if known {
+		for _, ext := range parseStrList(string(out)) {
+			enabled[ext] = true
+		}
+	}
+	installed := make(map[string]bool)
+	dirs, _ := filepath.Glob(s.path(s.home + "/.local/share/gnome-shell/extensions/*/metadata.json"))
+	for _, file := range dirs {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var meta extMetadata
+		if json.Unmarshal(data, &meta) != nil || meta.UUID == "" {
+			continue
+		}
+		installed[meta.UUID] = true
+		if meta.Version == 0 {
+			// not from the extensions site, resolved when installing
+			s.addCaptured("Installing Gnome Extension "+meta.UUID, "InstallGnomeExt", meta.UUID)
+		} else {
+			s.addCaptured("Installing Gnome Extension "+meta.UUID, "InstallGnomeExt", meta.UUID, fmt.Sprint(meta.Version))
+		}
+		if known && !enabled[meta.UUID] {
+			// InstallGnomeExt enables what it installs
+			s.addCaptured("Disabling Gnome Extension "+meta.UUID, "DisableGnomeExt", meta.UUID)
+		}
+	}
+	names := make([]string, 0, len(enabled))
+	for ext := range enabled {
+		names = append(names, ext)
+	}
+	sort.Strings(names)
+	for _, ext := range names {
+		if !installed[ext] {
+			s.addCaptured("Enabling Gnome Extension "+ext, "EnableGnomeExt", ext)
+		}
+	}
+}
+
+func (s *Set) captureDconf(dir string) error {
+	out, err := s.userDconf("dump", "/").Output()
+	if err != nil || len(out) == 0 {
+		return nil
+	}
+	sections, err := parseDconf(string(out))
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(filepath.Join(dir, "dconf.toml"), []byte(formatDconf(filterDconf(sections))), 0644)
+	if err != nil {
+		return err
+	}
+	s.addCaptured("Loading Gnome Settings", "InstallGnomeSettings", "dconf.toml")
+	return nil
+}
+
+func (s *Set) captureShell() {
+	entry, err := lookupPasswd(s.path("/etc/passwd"), s.user)
+	if err != nil {
+		return
+	}
+	shell := filepath.Base(entry.Shell)
+	switch shell {
+	case "", "bash", "sh", "nologin", "false":
+	default:
+		s.addCaptured("Setting login shell", "SetShell", shell)
+	}
+}
+
+// parseStrList reads a GVariant string array such as ['a', 'b']
+func parseStrList(v string) []string {
+	v = strings.TrimSpace(v)
+	v = strings.TrimPrefix(v, "@as ")
+	v = strings.TrimSuffix(strings.TrimPrefix(v, "["), "]")
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		item = strings.Trim(strings.TrimSpace(item), `'"`)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -2,21 +2,27 @@ package run
 
 import (
 	"errors"
-	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"strings"
-	"time"
 )
 
 func LoadCommands() {
 	SetCommand("CreateDir", execCreateDir)
 	SetCommand("AppendFile", execAppendFile)
+	SetCommand("RemoveBlock", execRemoveBlock)
 	SetCommand("AddGroup", execAddGroup)
-	SetCommand("AssignGroups", execAssignGroups)
+	SetUserCommand("AssignGroups", execAssignGroups)
 	SetCommand("PrimaryGroup", execPrimaryGroup)
 	SetCommand("Replace", execReplace)
+	SetPlanCommand("Replace")
+	SetCommand("IniSet", execIniSet)
+	SetCommand("IniUnset", execIniUnset)
+	SetCommand("SetVar", execSetVar)
+	SetCommand("UnsetVar", execUnsetVar)
+	SetCommand("EnsureLine", execEnsureLine)
+	SetCommand("RemoveLine", execRemoveLine)
 	SetCommand("ChangeOwner", execChangeOwner)
 	SetCommand("ChangePerm", execChangePerm)
 	SetCommand("ReloadSysctl", execReloadSysctl)
@@ -25,70 +31,95 @@ func LoadCommands() {
 	SetCommand("AddArch", execAddArch)
 	SetCommand("ReloadUnits", execReloadUnits)
 	SetCommand("InstallPackages", execInstallPackages)
+	SetCommand("RemovePackages", execRemovePackages)
 	SetCommand("InstallFlatpaks", execInstallFlatpaks)
 	SetCommand("EnableFlatpak", execEnableFlatpak)
+	SetCommand("AddFlatpakRemote", execAddFlatpakRemote)
+	SetCommand("InstallFlatpak", execInstallFlatpak)
+	SetCommand("FlatpakOverride", execFlatpakOverride)
+	SetCommand("UpdateFlatpaks", execUpdateFlatpaks)
+	SetCommand("RemoveFlatpaks", execRemoveFlatpaks)
+	SetUserCommand("AddUserFlatpakRemote", execAddUserFlatpakRemote)
+	SetUserCommand("InstallUserFlatpak", execInstallUserFlatpak)
+	SetUserCommand("UserFlatpakOverride", execUserFlatpakOverride)
+	SetUserCommand("UpdateUserFlatpaks", execUpdateUserFlatpaks)
+	SetUserCommand("RemoveUserFlatpaks", execRemoveUserFlatpaks)
 	SetCommand("InstallPip", execInstallPip)
 	SetCommand("EnableAptFile", execEnableAptFile)
 	SetCommand("EnableService", execEnableService)
+	SetCommand("InstallUnit", execInstallUnit)
+	SetCommand("InstallDropIn", execInstallDropIn)
+	SetCommand("SystemdUnit", execSystemdUnit)
+	SetUserCommand("InstallUserUnit", execInstallUserUnit)
+	SetUserCommand("InstallUserDropIn", execInstallUserDropIn)
+	SetUserCommand("SystemdUserUnit", execSystemdUserUnit)
+	SetCommand("InstallFonts", execInstallFonts)
+	SetUserCommand("InstallUserFonts", execInstallUserFonts)
+	SetCommand("InstallTheme", execInstallTheme)
+	SetUserCommand("InstallUserTheme", execInstallUserTheme)
+	SetUserCommand("SetTheme", execSetTheme)
 	SetCommand("UnzipFile", execUnzipFile)
 	SetCommand("Untar", execUntar)
 	SetCommand("AddUser", execAddUser)
 	SetCommand("CloneRepo", execCloneRepo)
 	SetCommand("CloneAndRun", execCloneAndRun)
-	SetCommand("CloneAndRunAsUser", execCloneAndRunAsUser)
-	SetCommand("InstallGnomeExt", execInstallGnomeExt)
-	SetCommand("EnableGnomeExt", execEnableGnomeExt)
-	SetCommand("InstallZshPlugin", execInstallZshPlugin)
-	SetCommand("EnableZsh", execEnableZsh)
-	SetCommand("InstallGnomeSettings", execInstallGnomeSettings)
+	SetUserCommand("CloneAndRunAsUser", execCloneAndRunAsUser)
+	SetUserCommand("InstallGnomeExt", execInstallGnomeExt)
+	SetUserCommand("EnableGnomeExt", execEnableGnomeExt)
+	SetUserCommand("DisableGnomeExt", execDisableGnomeExt)
+	SetUserCommand("UninstallGnomeExt", execUninstallGnomeExt)
+	SetUserCommand("InstallZshPlugin", execInstallZshPlugin)
+	SetUserCommand("RemoveZshPlugin", execRemoveZshPlugin)
+	SetUserCommand("EnableZshPlugins", execEnableZshPlugins)
+	SetUserCommand("DisableZshPlugins", execDisableZshPlugins)
+	SetUserCommand("InstallZshTheme", execInstallZshTheme)
+	SetUserCommand("SetZshTheme", execSetZshTheme)
+	SetUserCommand("EnableZsh", execEnableZsh)
+	SetUserCommand("SetShell", execSetShell)
+	SetUserCommand("InstallFishPlugins", execInstallFishPlugins)
+	SetUserCommand("RemoveFishPlugins", execRemoveFishPlugins)
+	SetUserCommand("InstallBashIt", execInstallBashIt)
+	SetUserCommand("BashItEnable", execBashItEnable)
+	SetUserCommand("InstallBleSh", execInstallBleSh)
+	SetUserCommand("InstallGnomeSettings", execInstallGnomeSettings)
+	SetUserCommand("DconfLoad", execDconfLoad)
+	SetUserCommand("DconfSet", execDconfSet)
+	SetCommand("InstallDconfDb", execInstallDconfDb)
 	SetCommand("Run", execRun)
 	SetCommand("Download", execDownload)
 	SetCommand("AddRepoKey", execAddRepoKey)
 	SetCommand("SetPass", execSetPass)
 	SetCommand("CopyFile", execCopyFile)
-	SetCommand("InstallUserConfig", execInstallUserConfig)
+	SetCommand("RemoveFile", execRemoveFile)
+	SetUserCommand("InstallUserConfig", execInstallUserConfig)
+	SetUserCommand("InstallUserDir", execInstallUserDir)
+	SetUserCommand("LinkUserConfig", execLinkUserConfig)
+	for _, n := range []string{"AppendFile", "RemoveBlock", "Replace", "IniSet", "IniUnset", "SetVar", "UnsetVar",
+		"EnsureLine", "RemoveLine", "InstallFlatpak", "InstallUserFlatpak", "FlatpakOverride", "UserFlatpakOverride",
+		"RemoveFlatpaks", "RemoveUserFlatpaks", "InstallUnit", "InstallDropIn", "InstallUserUnit", "InstallUserDropIn",
+		"InstallFonts", "InstallUserFonts", "InstallTheme", "InstallUserTheme", "SetTheme", "InstallGnomeExt",
+		"EnableGnomeExt", "DisableGnomeExt", "UninstallGnomeExt", "InstallZshPlugin", "RemoveZshPlugin",
+		"EnableZshPlugins", "DisableZshPlugins", "InstallZshTheme", "SetZshTheme", "SetShell", "EnableZsh",
+		"InstallFishPlugins", "RemoveFishPlugins", "InstallBashIt", "BashItEnable", "InstallBleSh", "DconfLoad",
+		"DconfSet", "InstallDconfDb", "RemoveFile", "InstallUserDir", "LinkUserConfig"} {
+		SetReportCommand(n)
+	}
 }
 
 func execCreateDir(s *Set, param ...string) (string, error) {
 	dirName := param[0]
-	if _, err := os.Stat(dirName); !os.IsNotExist(err) {
+	if _, err := os.Stat(s.path(dirName)); !os.IsNotExist(err) {
 		var ok error
 		return "", ok
 	}
-	err := os.Mkdir(dirName, 0755)
-	return "", err
-}
-
-func execAppendFile(s *Set, param ...string) (string, error) {
-	cfg := param[0]
-	dst := param[1]
-	arg := []string{"-q", "flechade", dst}
-	Cmd := exec.Command("grep", arg...)
-	out, err := Cmd.CombinedOutput()
-	if err == nil {
-		return string(out), err
-	}
-
-	dstFile, err := os.OpenFile(dst, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return "", err
-	}
-	defer dstFile.Close()
-	cfgFile, err := s.files.Open(cfg)
-	if err != nil {
-		return "", err
-	}
-	defer cfgFile.Close()
-	fmt.Fprintln(dstFile, "# flechade START")
-	_, err = io.Copy(dstFile, cfgFile)
-	fmt.Fprintln(dstFile, "# flechade END")
+	err := os.Mkdir(s.path(dirName), 0755)
 	return "", err
 }
 
 func execAddGroup(s *Set, param ...string) (string, error) {
 	groupName := param[0]
 	argGroup := []string{groupName}
-	groupCmd := exec.Command("groupadd", argGroup...)
+	groupCmd := s.command("groupadd", argGroup...)
 	out, err := groupCmd.CombinedOutput()
 	if err == nil {
 		return string(out), err
@@ -104,7 +135,7 @@ func execAddGroup(s *Set, param ...string) (string, error) {
 func execAssignGroups(s *Set, param ...string) (string, error) {
 	groups := param[0]
 	args := []string{"-aG", groups, s.user}
-	Cmd := exec.Command("usermod", args...)
+	Cmd := s.command("usermod", args...)
 	out, err := Cmd.CombinedOutput()
 	return string(out), err
 }
@@ -113,25 +144,16 @@ func execPrimaryGroup(s *Set, param ...string) (string, error) {
 	user := param[0]
 	pg := param[1]
 	args := []string{"-g", pg, user}
-	Cmd := exec.Command("usermod", args...)
+	Cmd := s.command("usermod", args...)
 	out, err := Cmd.CombinedOutput()
 	return string(out), err
 }
 
-func execReplace(s *Set, param ...string) (string, error) {
-	subReg := param[0]
-	file := param[1]
-	argSed := []string{"-Ei", "-e", subReg, file}
-	sedCmd := exec.Command("sed", argSed...)
-	out, err := sedCmd.CombinedOutput()
-	return string(out), err
-}
-
 func execChangeOwner(s *Set, param ...string) (string, error) {
 	owner := param[0]
 	file := param[1]
 	argSed := []string{"-R", owner, file}
-	sedCmd := exec.Command("chown", argSed...)
+	sedCmd := s.command("chown", argSed...)
 	out, err := sedCmd.CombinedOutput()
 	return string(out), err
 }
@@ -139,28 +161,32 @@ func execChangeOwner(s *Set, param ...string) (string, error) {
 func execChangePerm(s *Set, param ...string) (string, error) {
 	mode := param[0]
 	file := param[1]
-	argChmod := []string{"-R", mode, file}
+	argChmod := []string{"-R", mode, s.path(file)}
 	chmodCmd := exec.Command("chmod", argChmod...)
 	out, err := chmodCmd.CombinedOutput()
 	return string(out), err
 }
 
 func execReloadSysctl(s *Set, param ...string) (string, error) {
+	if s.Root != "" {
+		// settings are picked up on first boot
+		return "", nil
+	}
 	args := []string{"-p"}
-	Cmd := exec.Command("sysctl", args...)
+	Cmd := s.command("sysctl", args...)
 	out, err := Cmd.CombinedOutput()
 	if err != nil {
 		return string(out), err
 	}
 	args = []string{"-a"}
-	Cmd = exec.Command("sysctl", args...)
+	Cmd = s.command("sysctl", args...)
 	out, err = Cmd.CombinedOutput()
 	return string(out), err
 }
 
 func execUpdateRepos(s *Set, param ...string) (string, error) {
 	args := []string{"update", "-y", "-o", "Dpkg::Options::=--force-confdef"}
-	Cmd := exec.Command("apt", args...)
+	Cmd := s.command("apt", args...)
 	Cmd.Env = os.Environ()
 	Cmd.Env = append(Cmd.Env, "DEBIAN_FRONTEND=noninteractive")
 	Cmd.Env = append(Cmd.Env, "DEBCONF_NONINTERACTIVE_SEEN=true")
@@ -172,7 +198,7 @@ func execUpdateRepos(s *Set, param ...string) (string, error) {
 
 func execUpgradePackages(s *Set, param ...string) (string, error) {
 	args := []string{"upgrade", "-y", "-o", "Dpkg::Options::=--force-confnew"}
-	Cmd := exec.Command("apt", args...)
+	Cmd := s.command("apt", args...)
 	Cmd.Env = os.Environ()
 	Cmd.Env = append(Cmd.Env, "DEBCONF_NONINTERACTIVE_SEEN=true")
 	Cmd.Env = append(Cmd.Env, "APT_LISTCHANGES_FRONTEND=none")
@@ -185,7 +211,7 @@ func execUpgradePackages(s *Set, param ...string) (string, error) {
 func execAddArch(s *Set, param ...string) (string, error) {
 	arch := param[0]
 	arg := []string{"--add-architecture", arch}
-	Cmd := exec.Command("dpkg", arg...)
+	Cmd := s.command("dpkg", arg...)
 	Cmd.Env = os.Environ()
 	Cmd.Env = append(Cmd.Env, "DEBCONF_NONINTERACTIVE_SEEN=true")
 	Cmd.Env = append(Cmd.Env, "DEBIAN_FRONTEND=noninteractive")
@@ -194,8 +220,11 @@ func execAddArch(s *Set, param ...string) (string, error) {
 }
 
 func execReloadUnits(s *Set, param ...string) (string, error) {
+	if s.Root != "" {
+		return "", nil
+	}
 	args := []string{"daemon-reload"}
-	Cmd := exec.Command("systemctl", args...)
+	Cmd := s.command("systemctl", args...)
 	out, err := Cmd.CombinedOutput()
 	return string(out), err
 }
@@ -205,7 +234,7 @@ func execInstallPackages(s *Set, param ...string) (string, error) {
 	arg := []string{"install", "-y", "-o", "Dpkg::Options::=--force-confnew"}
 	plist := strings.Split(pkgs, " ")
 	arg = append(arg, plist...)
-	Cmd := exec.Command("apt", arg...)
+	Cmd := s.command("apt", arg...)
 	Cmd.Env = os.Environ()
 	Cmd.Env = append(Cmd.Env, "DEBCONF_NONINTERACTIVE_SEEN=true")
 	Cmd.Env = append(Cmd.Env, "APT_LISTCHANGES_FRONTEND=none")
@@ -220,7 +249,7 @@ func execInstallFlatpaks(s *Set, param ...string) (string, error) {
 	arg := []string{"install", "--noninteractive", "--assumeyes", "-v"}
 	plist := strings.Split(pkgs, " ")
 	arg = append(arg, plist...)
-	Cmd := exec.Command("flatpak", arg...)
+	Cmd := s.command("flatpak", arg...)
 	out, err := Cmd.CombinedOutput()
 	return string(out), err
 }
@@ -230,7 +259,7 @@ func execInstallPip(s *Set, param ...string) (string, error) {
 	args := []string{"-m", "pip", "install", "--break-system-packages"}
 	plist := strings.Split(pkgs, " ")
 	args = append(args, plist...)
-	Cmd := exec.Command("python3", args...)
+	Cmd := s.command("python3", args...)
 	out, err := Cmd.CombinedOutput()
 	return string(out), err
 }
@@ -240,7 +269,7 @@ func execEnableAptFile(s *Set, param ...string) (string, error) {
 	if err != nil {
 		return out, err
 	}
-	Cmd := exec.Command("apt-file", "update")
+	Cmd := s.command("apt-file", "update")
 	output, err := Cmd.CombinedOutput()
 	return string(output), err
 }
@@ -253,21 +282,21 @@ func execEnableFlatpak(s *Set, param ...string) (string, error) {
 	}
 	//Adding flathub repo
 	args := []string{"remote-add", "--if-not-exists", "flathub", "https://flathub.org/repo/flathub.flatpakrepo"}
-	Cmd := exec.Command("flatpak", args...)
+	Cmd := s.command("flatpak", args...)
 	output, err := Cmd.CombinedOutput()
 	if err != nil {
 		return string(output), err
 	}
 	//Pulling available packages
 	args = []string{"update", "--noninteractive", "--assumeyes"}
-	Cmd = exec.Command("flatpak", args...)
+	Cmd = s.command("flatpak", args...)
 	output, err = Cmd.CombinedOutput()
 	if err != nil {
 		return string(output), err
 	}
 	//Prividing access to themes
 	args = []string{"override", "--filesystem=~/.themes", "--filesystem=~/.icons", "--filesystem=xdg-config/gtk-4.0"}
-	Cmd = exec.Command("flatpak", args...)
+	Cmd = s.command("flatpak", args...)
 	output, err = Cmd.CombinedOutput()
 	return string(output), err
 }
@@ -275,6 +304,9 @@ func execEnableFlatpak(s *Set, param ...string) (string, error) {
 func execEnableService(s *Set, param ...string) (string, error) {
 	svc := param[0]
 	arg := []string{"enable", svc}
+	if s.Root != "" {
+		arg = append([]string{"--root", s.Root}, arg...)
+	}
 	Cmd := exec.Command("systemctl", arg...)
 	out, err := Cmd.CombinedOutput()
 	return string(out), err
@@ -283,7 +315,7 @@ func execEnableService(s *Set, param ...string) (string, error) {
 func execUnzipFile(s *Set, param ...string) (string, error) {
 	file := param[0]
 	dir := param[1]
-	arg := []string{"-n", file, "-d", dir}
+	arg := []string{"-n", s.path(file), "-d", s.path(dir)}
 	Cmd := exec.Command("unzip", arg...)
 	out, err := Cmd.CombinedOutput()
 	return string(out), err
@@ -292,7 +324,7 @@ func execUnzipFile(s *Set, param ...string) (string, error) {
 func execUntar(s *Set, param ...string) (string, error) {
 	file := param[0]
 	dir := param[1]
-	arg := []string{"xf", file, "-C", dir, "--strip-components=1"}
+	arg := []string{"xf", s.path(file), "-C", s.path(dir), "--strip-components=1"}
 	Cmd := exec.Command("tar", arg...)
 	out, err := Cmd.CombinedOutput()
 	return string(out), err
@@ -301,7 +333,7 @@ func execUntar(s *Set, param ...string) (string, error) {
 func execAddUser(s *Set, param ...string) (string, error) {
 	name := param[0]
 	arg := []string{"-m", name}
-	Cmd := exec.Command("useradd", arg...)
+	Cmd := s.command("useradd", arg...)
 	out, err := Cmd.CombinedOutput()
 	if err == nil {
 		return string(out), err
@@ -316,7 +348,7 @@ func execAddUser(s *Set, param ...string) (string, error) {
 func execCloneRepo(s *Set, param ...string) (string, error) {
 	repo := param[0]
 	dir := param[1]
-	arg := []string{"clone", "--depth", "1", repo, dir}
+	arg := []string{"clone", "--depth", "1", repo, s.path(dir)}
 	Cmd := exec.Command("git", arg...)
 	Cmd.Env = os.Environ()
 	Cmd.Env = append(Cmd.Env, "GIT_SSL_NO_VERIFY=true")
@@ -332,14 +364,14 @@ func execCloneAndRun(s *Set, param ...string) (string, error) {
 	rname := repo[last : rlen-4]
 	clist := strings.Split(command, " ")
 	xfile := clist[0]
-	if _, err := os.Stat("/tmp/" + rname + "/" + xfile); errors.Is(err, os.ErrNotExist) {
+	if _, err := os.Stat(s.path("/tmp/" + rname + "/" + xfile)); errors.Is(err, os.ErrNotExist) {
 		out, err := execCloneRepo(s, repo, "/tmp/"+rname)
 		if err != nil {
 			return out, err
 		}
 	}
 	args := clist[1:]
-	Cmd := exec.Command("/tmp/"+rname+"/"+xfile, args...)
+	Cmd := s.command("/tmp/"+rname+"/"+xfile, args...)
 	output, err := Cmd.CombinedOutput()
 	return string(output), err
 }
@@ -352,84 +384,71 @@ func execCloneAndRunAsUser(s *Set, param ...string) (string, error) {
 	rname := repo[last:rlen-4] + ".usr"
 	clist := strings.Split(command, " ")
 	xfile := clist[0]
-	if _, err := os.Stat("/tmp/" + rname + "/" + xfile); errors.Is(err, os.ErrNotExist) {
+	if _, err := os.Stat(s.path("/tmp/" + rname + "/" + xfile)); errors.Is(err, os.ErrNotExist) {
 		out, err := execCloneRepo(s, repo, "/tmp/"+rname)
 		if err != nil {
 			return out, err
 		}
 	}
 	chownArgs := []string{"-R", s.user, "/tmp/" + rname}
-	chownCmd := exec.Command("chown", chownArgs...)
+	chownCmd := s.command("chown", chownArgs...)
 	chownout, err := chownCmd.CombinedOutput()
 	if err != nil {
 		return string(chownout), err
 	}
-	args := clist[1:]
-	concParms := strings.Join(args, " ")
-	concCmd := "/tmp/" + rname + "/" + xfile + " " + concParms
-	flags := append([]string{s.user, "-c"}, concCmd)
-	Cmd := exec.Command("su", flags...)
+	Cmd := s.userCmd("/tmp/"+rname+"/"+xfile, clist[1:]...)
 	output, err := Cmd.CombinedOutput()
 	return string(output), err
 }
 
 func execInstallGnomeExt(s *Set, param ...string) (string, error) {
 	extid := param[0]
-	version := param[1]
-	//last := strings.LastIndex(url, "/")
-	//file := url[last:]
+	version := ""
+	if len(param) > 1 {
+		version = param[1]
+	}
+	if version == "" {
+		shell, err := s.shellVersion()
+		if err != nil {
+			return "", err
+		}
+		version, err = resolveExtVersion(extid, shell)
+		if err != nil {
+			return "", err
+		}
+	}
 
 	file := strings.ReplaceAll(extid, "@", "")
-	url := "https://extensions.gnome.org/extension-data/" + file + ".v" + version + ".shell-extension.zip"
+	url := ExtensionsURL + "/extension-data/" + file + ".v" + version + ".shell-extension.zip"
 
-	out, err := execDownload(s, url, "/tmp/"+file)
+	data, err := fetchArchive(url)
 	if err != nil {
-		return out, err
-	}
-	Cmd := exec.Command("gnome-extensions", "install", "--force", "/tmp/"+file)
-	output, err := Cmd.CombinedOutput()
-	if err != nil {
-		return string(output), err
+		return "", err
 	}
-	//Activating the extension in session
-	Cmd = exec.Command("su", "-", s.user, "-c",
-		"DBUS_SESSION_BUS_ADDRESS=unix:path=/run/user/"+s.uid+"/bus busctl --user call org.gnome.Shell.Extensions /org/gnome/Shell/Extensions org.gnome.Shell.Extensions InstallRemoteExtension s "+extid)
-	time.Sleep(2 * time.Second)
-	output, err = Cmd.CombinedOutput()
-	time.Sleep(2 * time.Second)
+	out, err := s.unpackExtension(extid, data)
 	if err != nil {
-		if err.Error() == "exit status 1" {
-			//ignore disconnect
-			var e error
-			return "", e
-		}
+		return out, err
 	}
-	return string(output), err
+	enabled, err := execEnableGnomeExt(s, extid)
+	return out + "\n" + enabled, err
 }
 
 func execEnableGnomeExt(s *Set, param ...string) (string, error) {
 	ext := param[0]
 
-	Cmd := exec.Command("su", s.user, "-c", "DBUS_SESSION_BUS_ADDRESS=unix:path=/run/user/"+s.uid+"/bus gnome-extensions enable "+ext)
-	output, err := Cmd.CombinedOutput()
-	return string(output), err
-}
-
-func execInstallZshPlugin(s *Set, param ...string) (string, error) {
-	repo := param[0]
-
-	rlen := len(repo)
-	last := strings.LastIndex(repo, "/")
-	rname := repo[last : rlen-4]
-	Cmd := exec.Command("su", s.user, "-c", "git clone --depth=1 "+repo+" ~/.oh-my-zsh/custom/plugins/"+rname)
-	output, err := Cmd.CombinedOutput()
-	return string(output), err
-}
-
-func execEnableZsh(s *Set, param ...string) (string, error) {
-	Cmd := exec.Command("usermod", "-s", "/bin/zsh", s.user)
-	output, err := Cmd.CombinedOutput()
-	return string(output), err
+	out, err := s.enableExtension(ext)
+	if err != nil || !s.hasSession() {
+		return out, err
+	}
+	// extensions unpacked while the shell runs are only loaded on next login
+	ok, err := s.shellExt("EnableExtension", ext)
+	if err != nil {
+		return out, err
+	}
+	if ok != "true" {
+		return out + " (active after next login)", nil
+	}
+	return out, nil
 }
 
 func execInstallGnomeSettings(s *Set, param ...string) (string, error) {
@@ -439,7 +458,7 @@ func execInstallGnomeSettings(s *Set, param ...string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	Cmd := exec.Command("su", s.user, "-c", "DBUS_SESSION_BUS_ADDRESS=unix:path=/run/user/"+s.uid+"/bus dconf load /")
+	Cmd := s.userDconf("load", "/")
 	buf, _ := io.ReadAll(cfgFile)
 	Cmd.Stdin = strings.NewReader(string(buf))
 	output, err := Cmd.CombinedOutput()
@@ -450,7 +469,7 @@ func execRun(s *Set, param ...string) (string, error) {
 	cmd := param[0]
 
 	args := []string{}
-	Cmd := exec.Command(cmd, args...)
+	Cmd := s.command(cmd, args...)
 	out, err := Cmd.CombinedOutput()
 	return string(out), err
 }
@@ -459,7 +478,7 @@ func execDownload(s *Set, param ...string) (string, error) {
 	url := param[0]
 	file := param[1]
 
-	args := []string{"--continue", url, "-O", file}
+	args := []string{"--continue", url, "-O", s.path(file)}
 	Cmd := exec.Command("wget", args...)
 	out, err := Cmd.CombinedOutput()
 	return string(out), err
@@ -469,14 +488,14 @@ func execAddRepoKey(s *Set, param ...string) (string, error) {
 	URL := param[0]
 	file := param[1]
 
-	args := []string{"--continue", URL, "-O", file}
+	args := []string{"--continue", URL, "-O", s.path(file)}
 	Cmd := exec.Command("wget", args...)
 	out, err := Cmd.CombinedOutput()
 
 	if err != nil {
 		return string(out), err
 	}
-	args = []string{"--batch", "--yes", "--dearmor", file}
+	args = []string{"--batch", "--yes", "--dearmor", s.path(file)}
 	Cmd = exec.Command("gpg", args...)
 	out, err = Cmd.CombinedOutput()
 
@@ -488,7 +507,7 @@ func execSetPass(s *Set, param ...string) (string, error) {
 	pass := param[1]
 
 	args := []string{}
-	Cmd := exec.Command("chpasswd", args...)
+	Cmd := s.command("chpasswd", args...)
 	Cmd.Stdin = strings.NewReader(user + ":" + pass)
 	out, err := Cmd.CombinedOutput()
 	return string(out), err
@@ -498,7 +517,7 @@ func execCopyFile(s *Set, param ...string) (string, error) {
 	fileName := param[0]
 	dstDir := param[1]
 
-	dstFile, err := os.OpenFile(dstDir+"/"+fileName, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	dstFile, err := os.OpenFile(s.path(dstDir+"/"+fileName), os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return "", err
 	}
@@ -516,23 +535,23 @@ func execInstallUserConfig(s *Set, param ...string) (string, error) {
 	fileName := param[0]
 	relDir := param[1]
 
-	dstDir := "/home/" + s.user + "/" + relDir
+	dstDir := s.home + "/" + relDir
 	dstName := dstDir + "/" + fileName
 
-	err := os.MkdirAll(dstDir, 0755)
+	err := os.MkdirAll(s.path(dstDir), 0755)
 	if err != nil {
 		return "", err
 	}
 
 	if relDir != "" {
 		parts := strings.Split(relDir, "/")
-		out, err := execChangeOwner(s, s.user, "/home/"+s.user+"/"+parts[0])
+		out, err := execChangeOwner(s, s.user+":"+s.group, s.home+"/"+parts[0])
 		if err != nil {
 			return out, err
 		}
 	}
 
-	dstFile, err := os.OpenFile(dstName, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0744)
+	dstFile, err := os.OpenFile(s.path(dstName), os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0744)
 	if err != nil {
 		return "", err
 	}
@@ -546,6 +565,6 @@ func execInstallUserConfig(s *Set, param ...string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	out, err := execChangeOwner(s, s.user, dstName)
+	out, err := execChangeOwner(s, s.user+":"+s.group, dstName)
 	return out, err
 }
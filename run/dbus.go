@@ -0,0 +1,151 @@
+package run
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	shellExtDest  = "org.gnome.Shell.Extensions"
+	shellExtPath  = "/org/gnome/Shell/Extensions"
+	shellExtIface = "org.gnome.Shell.Extensions"
+)
+
+// ShellExtCall calls a method of the GNOME Shell extensions interface on
+// the session bus given by DBUS_SESSION_BUS_ADDRESS and returns its reply.
+// It runs in a child process started with the credentials of the target
+// user, as session buses only accept connections from their owner.
+func ShellExtCall(method string, args ...string) (string, error) {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return "", err
+	}
+	params := make([]interface{}, len(args))
+	for i, arg := range args {
+		params[i] = arg
+	}
+	call := conn.Object(shellExtDest, shellExtPath).Call(shellExtIface+"."+method, 0, params...)
+	if call.Err != nil {
+		return "", call.Err
+	}
+	var reply []string
+	for _, v := range call.Body {
+		reply = append(reply, fmt.Sprint(v))
+	}
+	return strings.Join(reply, " "), nil
+}
+
+// permits checks the permission bits of info for uid and gid, ignoring
+// supplementary groups
+func permits(info os.FileInfo, uid uint32, gid uint32, bit os.FileMode) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+	mode := info.Mode().Perm()
+	switch {
+	case st.Uid == uid:
+		return mode&(bit<<6) != 0
+	case st.Gid == gid:
+		return mode&(bit<<3) != 0
+	}
+	return mode&bit != 0
+}
+
+// canExec reports whether uid can run file, which also needs search
+// permission on every directory leading to it
+func canExec(file string, uid uint32, gid uint32) bool {
+	info, err := os.Stat(file)
+	if err != nil || !permits(info, uid, gid, 01) {
+		return false
+	}
+	for dir := filepath.Dir(file); ; dir = filepath.Dir(dir) {
+		info, err := os.Stat(dir)
+		if err != nil || !permits(info, uid, gid, 01) {
+			return false
+		}
+		if dir == filepath.Dir(dir) {
+			return true
+		}
+	}
+}
+
+// userExecutable returns a copy of flechade the target user can run: the
+// running binary itself or, when it lives where the user has no access
+// (a build in root's home), a temporary copy to remove afterwards
+func userExecutable(uid uint32, gid uint32) (string, bool, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return "", false, err
+	}
+	if canExec(self, uid, gid) {
+		return self, false, nil
+	}
+	data, err := os.ReadFile(self)
+	if err != nil {
+		return "", false, err
+	}
+	tmp, err := os.CreateTemp("", "flechade-shell-ext-")
+	if err != nil {
+		return "", false, err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0755)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", false, err
+	}
+	return tmp.Name(), true, nil
+}
+
+// shellExt calls method on the GNOME Shell of the target user through a
+// copy of flechade running as that user
+func (s *Set) shellExt(method string, args ...string) (string, error) {
+	us := s.session()
+	if us.Bus == "" {
+		return "", errors.New("user " + s.user + " has no session bus")
+	}
+	uid, _ := strconv.ParseUint(s.uid, 10, 32)
+	gid, _ := strconv.ParseUint(s.gid, 10, 32)
+	self, temporary, err := userExecutable(uint32(uid), uint32(gid))
+	if err != nil {
+		return "", err
+	}
+	if temporary {
+		defer os.Remove(self)
+	}
+	Cmd := exec.Command(self, append([]string{"shell-ext", method}, args...)...)
+	Cmd.Env = append([]string{
+		"HOME=" + s.home,
+		"USER=" + s.user,
+		"PATH=/usr/bin:/bin",
+	}, us.env()...)
+	Cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)},
+	}
+	var stdout, stderr strings.Builder
+	Cmd.Stdout = &stdout
+	Cmd.Stderr = &stderr
+	err = Cmd.Run()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", errors.New(method + " " + strings.Join(args, " ") + ": " + msg)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
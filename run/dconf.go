@@ -0,0 +1,318 @@
+package run
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dconf keys holding window geometry and other state that changes on every
+// session and should not be part of a set
+var volatileKeys = []string{
+	"window-size",
+	"window-state",
+	"window-width",
+	"window-height",
+	"window-x",
+	"window-y",
+	"window-maximized",
+	"window-position",
+	"is-maximized",
+	"maximized",
+	"last-panel",
+	"last-folder",
+	"recent-files",
+	"sidebar-width",
+	"initial-size",
+}
+
+type dconfKey struct {
+	Name  string
+	Value string
+}
+
+type dconfSection struct {
+	Path string
+	Keys []dconfKey
+}
+
+func isVolatileKey(key string) bool {
+	for _, k := range volatileKeys {
+		if key == k {
+			return true
+		}
+	}
+	return false
+}
+
+// key returns the absolute path of the key name of the section
+func (sec dconfSection) key(name string) string {
+	if sec.Path == "/" {
+		return "/" + name
+	}
+	return "/" + sec.Path + "/" + name
+}
+
+// parseDconf reads a keyfile as written by dconf dump
+func parseDconf(data string) ([]dconfSection, error) {
+	var sections []dconfSection
+	for n, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			path := strings.Trim(line[1:len(line)-1], "/")
+			if path == "" {
+				// keys at the root of the database
+				path = "/"
+			}
+			sections = append(sections, dconfSection{Path: path})
+		default:
+			kv := strings.SplitN(line, "=", 2)
+			if len(kv) != 2 || len(sections) == 0 {
+				return nil, fmt.Errorf("invalid dconf keyfile line %d: %s", n+1, line)
+			}
+			last := &sections[len(sections)-1]
+			last.Keys = append(last.Keys, dconfKey{Name: strings.TrimSpace(kv[0]), Value: strings.TrimSpace(kv[1])})
+		}
+	}
+	return sections, nil
+}
+
+func formatDconf(sections []dconfSection) string {
+	var out strings.Builder
+	for _, sec := range sections {
+		if len(sec.Keys) == 0 {
+			continue
+		}
+		out.WriteString("[" + sec.Path + "]\n")
+		for _, k := range sec.Keys {
+			out.WriteString(k.Name + "=" + k.Value + "\n")
+		}
+		out.WriteString("\n")
+	}
+	return out.String()
+}
+
+// filterDconf keeps the keys selected by paths (all when empty) leaving out
+// volatile keys unless they were named explicitly
+func filterDconf(sections []dconfSection, paths ...string) []dconfSection {
+	var result []dconfSection
+	for _, sec := range sections {
+		kept := dconfSection{Path: sec.Path}
+		for _, k := range sec.Keys {
+			full := sec.key(k.Name)
+			explicit := false
+			selected := len(paths) == 0
+			for _, p := range paths {
+				p = "/" + strings.Trim(p, "/")
+				if full == p {
+					explicit = true
+				}
+				if full == p || strings.HasPrefix(full, p+"/") || p == "/" {
+					selected = true
+				}
+			}
+			if selected && (explicit || !isVolatileKey(k.Name)) {
+				kept.Keys = append(kept.Keys, k)
+			}
+		}
+		if len(kept.Keys) > 0 {
+			result = append(result, kept)
+		}
+	}
+	return result
+}
+
+// diffDconf returns the keys in wanted whose value differs from current
+func diffDconf(wanted []dconfSection, current []dconfSection) []dconfSection {
+	values := make(map[string]string)
+	for _, sec := range current {
+		for _, k := range sec.Keys {
+			values[sec.key(k.Name)] = k.Value
+		}
+	}
+	var changed []dconfSection
+	for _, sec := range wanted {
+		diff := dconfSection{Path: sec.Path}
+		for _, k := range sec.Keys {
+			if cur, ok := values[sec.key(k.Name)]; !ok || cur != k.Value {
+				diff.Keys = append(diff.Keys, k)
+			}
+		}
+		if len(diff.Keys) > 0 {
+			changed = append(changed, diff)
+		}
+	}
+	return changed
+}
+
+// applyDconf loads the keys that differ from the user's database and
+// reports them
+func (s *Set) applyDconf(wanted []dconfSection) (string, error) {
+	dump, err := s.userDconf("dump", "/").Output()
+	if err != nil {
+		return string(dump), err
+	}
+	current, err := parseDconf(string(dump))
+	if err != nil {
+		return "", err
+	}
+	changed := diffDconf(wanted, current)
+	if len(changed) == 0 {
+		return "no dconf keys changed", nil
+	}
+	Cmd := s.userDconf("load", "/")
+	Cmd.Stdin = strings.NewReader(formatDconf(changed))
+	out, err := Cmd.CombinedOutput()
+	if err != nil {
+		return string(out), err
+	}
+	var report strings.Builder
+	for _, sec := range changed {
+		for _, k := range sec.Keys {
+			fmt.Fprintf(&report, "changed %s=%s\n", sec.key(k.Name), k.Value)
+		}
+	}
+	return report.String(), nil
+}
+
+func execDconfLoad(s *Set, param ...string) (string, error) {
+	cfg := param[0]
+	paths := param[1:]
+
+	data, err := fs.ReadFile(s.files, cfg)
+	if err != nil {
+		return "", err
+	}
+	sections, err := parseDconf(string(data))
+	if err != nil {
+		return "", err
+	}
+	return s.applyDconf(filterDconf(sections, paths...))
+}
+
+func execDconfSet(s *Set, param ...string) (string, error) {
+	key := strings.Trim(param[0], "/")
+	value := param[1]
+
+	if key == "" {
+		return "", errors.New("invalid dconf key: " + param[0])
+	}
+	sec := dconfSection{Path: "/", Keys: []dconfKey{{Name: key, Value: value}}}
+	if last := strings.LastIndex(key, "/"); last >= 0 {
+		sec = dconfSection{Path: key[:last], Keys: []dconfKey{{Name: key[last+1:], Value: value}}}
+	}
+	sections := []dconfSection{sec}
+	return s.applyDconf(sections)
+}
+
+// writeIfChanged writes data into file unless it already holds it
+func writeIfChanged(file string, data []byte, perm fs.FileMode) (bool, error) {
+	current, err := os.ReadFile(file)
+	if err == nil && bytes.Equal(current, data) {
+		return false, nil
+	}
+	err = os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return false, err
+	}
+	return true, os.WriteFile(file, data, perm)
+}
+
+// ensureDconfProfile adds the system database to /etc/dconf/profile/user
+// keeping the user database first so users can still change unlocked keys
+func (s *Set) ensureDconfProfile(db string) (bool, error) {
+	profile := s.path("/etc/dconf/profile/user")
+	data, err := os.ReadFile(profile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(data) == 0 {
+		lines = []string{"user-db:user"}
+	}
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "system-db:"+db {
+			return false, nil
+		}
+	}
+	lines = append(lines, "system-db:"+db)
+	return writeIfChanged(profile, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+func execInstallDconfDb(s *Set, param ...string) (string, error) {
+	db := param[0]
+	cfg := param[1]
+	locks := param[2:]
+
+	data, err := fs.ReadFile(s.files, cfg)
+	if err != nil {
+		return "", err
+	}
+	sections, err := parseDconf(string(data))
+	if err != nil {
+		return "", err
+	}
+	sections = filterDconf(sections)
+	dbDir := s.path("/etc/dconf/db/" + db + ".d")
+	var report strings.Builder
+
+	changed, err := writeIfChanged(filepath.Join(dbDir, "50-flechade-"+filepath.Base(cfg)), []byte(formatDconf(sections)), 0644)
+	if err != nil {
+		return "", err
+	}
+	if changed {
+		fmt.Fprintf(&report, "updated %s keyfile\n", db)
+	}
+
+	var lockList []string
+	for _, lock := range locks {
+		if lock != "*" {
+			lockList = append(lockList, "/"+strings.Trim(lock, "/"))
+			continue
+		}
+		// lock every key in the keyfile
+		for _, sec := range sections {
+			for _, k := range sec.Keys {
+				lockList = append(lockList, sec.key(k.Name))
+			}
+		}
+	}
+	lockFile := filepath.Join(dbDir, "locks", "flechade-"+filepath.Base(cfg))
+	if len(lockList) > 0 {
+		changed, err = writeIfChanged(lockFile, []byte(strings.Join(lockList, "\n")+"\n"), 0644)
+		if err != nil {
+			return "", err
+		}
+		if changed {
+			fmt.Fprintf(&report, "updated %s locks\n", db)
+		}
+	} else if _, err := os.Stat(lockFile); err == nil {
+		err = os.Remove(lockFile)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&report, "removed %s locks\n", db)
+	}
+
+	changed, err = s.ensureDconfProfile(db)
+	if err != nil {
+		return "", err
+	}
+	if changed {
+		fmt.Fprintf(&report, "added %s to user profile\n", db)
+	}
+	if report.Len() == 0 {
+		return "dconf database " + db + " unchanged", nil
+	}
+	out, err := s.command("dconf", "update").CombinedOutput()
+	if err != nil {
+		return string(out), err
+	}
+	return report.String(), nil
+}
@@ -0,0 +1,299 @@
+package run
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// writeFileAtomic replaces file through a temporary file in the same
+// directory, keeping the mode and owner of the file it replaces. Links
+// are resolved by the caller with resolvePath, so a symlink is left in
+// place and the file it points to inside the root is replaced.
+func writeFileAtomic(file string, data []byte, perm fs.FileMode) error {
+	uid, gid := -1, -1
+	if info, err := os.Stat(file); err == nil {
+		perm = info.Mode().Perm()
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			uid, gid = int(st.Uid), int(st.Gid)
+		}
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".flechade-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(tmp.Name(), perm)
+	if err != nil {
+		return err
+	}
+	if uid >= 0 {
+		err = os.Lchown(tmp.Name(), uid, gid)
+		if err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// inDir tells whether file is dir or below it
+func inDir(file string, dir string) bool {
+	file, dir = filepath.Clean(file), filepath.Clean(dir)
+	return file == dir || strings.HasPrefix(file, dir+"/")
+}
+
+func equalLines(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// editLines applies edit to the lines of file and saves the result when
+// it changed. Missing files are edited as empty when create is set.
+func (s *Set) editLines(file string, create bool, edit func([]string) []string) (bool, error) {
+	real := s.resolvePath(file)
+	if s.home != "" && inDir(file, s.home) && !inDir(real, s.resolvePath(s.home)) {
+		// a user could otherwise point a dotfile at a system file
+		return false, fmt.Errorf("refusing to edit %s, it links to %s outside the home of %s", file, real, s.user)
+	}
+	file = real
+	data, err := os.ReadFile(s.path(file))
+	missing := errors.Is(err, fs.ErrNotExist)
+	if missing && create {
+		err = nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var lines []string
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+	result := edit(lines)
+	if equalLines(result, lines) && (!missing || len(result) == 0) {
+		return false, nil
+	}
+	var content []byte
+	if len(result) > 0 {
+		content = []byte(strings.Join(result, "\n") + "\n")
+	}
+	return true, writeFileAtomic(s.path(file), content, 0644)
+}
+
+func editReport(changed bool, format string, args ...interface{}) string {
+	msg := fmt.Sprintf(format, args...)
+	if !changed {
+		return "unchanged: " + msg
+	}
+	return msg
+}
+
+func isComment(line string) bool {
+	line = strings.TrimSpace(line)
+	return line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";")
+}
+
+func iniSection(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+		return strings.TrimSpace(line[1 : len(line)-1]), true
+	}
+	return "", false
+}
+
+// iniKey returns the key of a key=value line and the separator it uses
+func iniKey(line string) (string, string, bool) {
+	if isComment(line) {
+		return "", "", false
+	}
+	eq := strings.Index(line, "=")
+	if eq < 0 {
+		return "", "", false
+	}
+	sep := "="
+	if strings.HasSuffix(line[:eq], " ") {
+		sep = " = "
+	}
+	return strings.TrimSpace(line[:eq]), sep, true
+}
+
+// setIniKey sets key in section, the part before the first section when
+// section is empty. A nil value removes the key.
+func setIniKey(lines []string, section string, key string, value *string) []string {
+	var result []string
+	inSection := section == ""
+	seen := inSection
+	// new keys go after the last key of the section
+	insertAt := 0
+	done := false
+	for _, line := range lines {
+		if name, ok := iniSection(line); ok {
+			inSection = name == section
+			if inSection {
+				seen = true
+				insertAt = len(result) + 1
+			}
+			result = append(result, line)
+			continue
+		}
+		if inSection {
+			if k, sep, ok := iniKey(line); ok && k == key {
+				if value != nil && !done {
+					result = append(result, key+sep+*value)
+					done = true
+				}
+				continue
+			}
+			if !isComment(line) {
+				insertAt = len(result) + 1
+			}
+		}
+		result = append(result, line)
+	}
+	if value == nil || done {
+		return result
+	}
+	if seen {
+		return insertIniKey(result, insertAt, key+"="+*value)
+	}
+	if len(result) > 0 && strings.TrimSpace(result[len(result)-1]) != "" {
+		result = append(result, "")
+	}
+	return append(result, "["+section+"]", key+"="+*value)
+}
+
+func insertIniKey(lines []string, at int, line string) []string {
+	if at < 0 || at > len(lines) {
+		at = len(lines)
+	}
+	lines = append(lines, "")
+	copy(lines[at+1:], lines[at:])
+	lines[at] = line
+	return lines
+}
+
+func execIniSet(s *Set, param ...string) (string, error) {
+	file := param[0]
+	section := param[1]
+	key := param[2]
+	value := param[3]
+
+	changed, err := s.editLines(file, true, func(lines []string) []string {
+		return setIniKey(lines, section, key, &value)
+	})
+	return editReport(changed, "set [%s] %s in %s", section, key, file), err
+}
+
+func execIniUnset(s *Set, param ...string) (string, error) {
+	file := param[0]
+	section := param[1]
+	key := param[2]
+
+	changed, err := s.editLines(file, false, func(lines []string) []string {
+		return setIniKey(lines, section, key, nil)
+	})
+	return editReport(changed, "removed [%s] %s from %s", section, key, file), err
+}
+
+// shellValue quotes values holding spaces unless they already are
+func shellValue(value string) string {
+	if strings.ContainsAny(value, " \t") && !strings.HasPrefix(value, "\"") && !strings.HasPrefix(value, "'") {
+		return "\"" + value + "\""
+	}
+	return value
+}
+
+// setShellVar sets KEY=value in a shell style file. A nil value removes it.
+func setShellVar(lines []string, key string, value *string) []string {
+	var result []string
+	done := false
+	for _, line := range lines {
+		trimmed := strings.TrimPrefix(strings.TrimSpace(line), "export ")
+		if strings.HasPrefix(trimmed, key+"=") {
+			if value != nil && !done {
+				prefix := ""
+				if strings.HasPrefix(strings.TrimSpace(line), "export ") {
+					prefix = "export "
+				}
+				result = append(result, prefix+key+"="+*value)
+				done = true
+			}
+			continue
+		}
+		result = append(result, line)
+	}
+	if value != nil && !done {
+		result = append(result, key+"="+*value)
+	}
+	return result
+}
+
+func execSetVar(s *Set, param ...string) (string, error) {
+	file := param[0]
+	key := param[1]
+	value := shellValue(param[2])
+
+	changed, err := s.editLines(file, true, func(lines []string) []string {
+		return setShellVar(lines, key, &value)
+	})
+	return editReport(changed, "set %s in %s", key, file), err
+}
+
+func execUnsetVar(s *Set, param ...string) (string, error) {
+	file := param[0]
+	key := param[1]
+
+	changed, err := s.editLines(file, false, func(lines []string) []string {
+		return setShellVar(lines, key, nil)
+	})
+	return editReport(changed, "removed %s from %s", key, file), err
+}
+
+func execEnsureLine(s *Set, param ...string) (string, error) {
+	file := param[0]
+	line := param[1]
+
+	changed, err := s.editLines(file, true, func(lines []string) []string {
+		if containsStr(lines, line) {
+			return lines
+		}
+		return append(lines, line)
+	})
+	return editReport(changed, "added line to %s", file), err
+}
+
+func execRemoveLine(s *Set, param ...string) (string, error) {
+	file := param[0]
+	line := param[1]
+
+	changed, err := s.editLines(file, false, func(lines []string) []string {
+		var result []string
+		for _, l := range lines {
+			if l != line {
+				result = append(result, l)
+			}
+		}
+		return result
+	})
+	return editReport(changed, "removed line from %s", file), err
+}
@@ -0,0 +1,170 @@
+package run
+
+import "strings"
+
+// flatpak runs flatpak on the system installation or, with user set, on
+// the installation of the target user
+func (s *Set) flatpak(user bool, args ...string) (string, error) {
+	if user {
+		out, err := s.userCmd("flatpak", append([]string{"--user"}, args...)...).CombinedOutput()
+		return strings.TrimSpace(string(out)), err
+	}
+	out, err := s.command("flatpak", append([]string{"--system"}, args...)...).CombinedOutput()
+	return strings.TrimSpace(string(out)), err
+}
+
+// flatpakColumn returns the words printed by a flatpak listing command
+func (s *Set) flatpakColumn(user bool, args ...string) []string {
+	out, err := s.flatpak(user, args...)
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(out)
+}
+
+func (s *Set) addFlatpakRemote(user bool, param ...string) (string, error) {
+	name := param[0]
+	url := param[1]
+
+	if containsStr(s.flatpakColumn(user, "remotes", "--columns=name"), name) {
+		return "unchanged: remote " + name, nil
+	}
+	return s.flatpak(user, "remote-add", "--if-not-exists", name, url)
+}
+
+func (s *Set) installFlatpak(user bool, param ...string) (string, error) {
+	app := param[0]
+	opts := parseOpts(param[1:])
+
+	ref := app
+	if branch := opts.get("branch", ""); branch != "" {
+		ref = app + "//" + branch
+	}
+	var report []string
+	if _, err := s.flatpak(user, "info", ref); err != nil {
+		out, err := s.flatpak(user, "install", "--noninteractive", "--assumeyes", opts.get("remote", "flathub"), ref)
+		if err != nil {
+			return out, err
+		}
+		report = append(report, "installed "+ref)
+	}
+	if commit := opts.get("commit", ""); commit != "" {
+		current, err := s.flatpak(user, "info", "--show-commit", ref)
+		if err != nil {
+			return current, err
+		}
+		if current != commit {
+			out, err := s.flatpak(user, "update", "--noninteractive", "--assumeyes", "--commit="+commit, ref)
+			if err != nil {
+				return out, err
+			}
+			report = append(report, "pinned "+ref+" to "+commit)
+		}
+		// keep updates from moving it away from the commit
+		if !containsStr(s.flatpakColumn(user, "mask"), app) {
+			out, err := s.flatpak(user, "mask", app)
+			if err != nil {
+				return out, err
+			}
+		}
+	}
+	if len(report) == 0 {
+		return "unchanged: " + ref, nil
+	}
+	return strings.Join(report, "\n"), nil
+}
+
+func (s *Set) overrideFlatpak(user bool, param ...string) (string, error) {
+	app := param[0]
+	args := []string{"override"}
+	for _, o := range param[1:] {
+		args = append(args, "--"+strings.TrimPrefix(o, "--"))
+	}
+	show := []string{"override", "--show"}
+	if app != "" && app != "global" {
+		args = append(args, app)
+		show = append(show, app)
+	}
+	before, _ := s.flatpak(user, show...)
+	out, err := s.flatpak(user, args...)
+	if err != nil {
+		return out, err
+	}
+	after, _ := s.flatpak(user, show...)
+	return editReport(before != after, "overrides of %s", app), nil
+}
+
+func (s *Set) updateFlatpaks(user bool, param ...string) (string, error) {
+	args := []string{"update", "--noninteractive", "--assumeyes"}
+	args = append(args, strings.Fields(strings.Join(param, " "))...)
+	return s.flatpak(user, args...)
+}
+
+func (s *Set) removeFlatpaks(user bool, param ...string) (string, error) {
+	apps := param[0]
+	opts := parseOpts(param[1:])
+
+	var report []string
+	for _, app := range strings.Fields(apps) {
+		if _, err := s.flatpak(user, "info", app); err != nil {
+			report = append(report, "unchanged: "+app+" not installed")
+			continue
+		}
+		args := []string{"uninstall", "--noninteractive", "--assumeyes"}
+		if opts.has("delete-data") {
+			args = append(args, "--delete-data")
+		}
+		out, err := s.flatpak(user, append(args, app)...)
+		if err != nil {
+			return out, err
+		}
+		report = append(report, "removed "+app)
+	}
+	if opts.has("unused") {
+		out, err := s.flatpak(user, "uninstall", "--noninteractive", "--assumeyes", "--unused")
+		if err != nil {
+			return out, err
+		}
+	}
+	return strings.Join(report, "\n"), nil
+}
+
+func execAddFlatpakRemote(s *Set, param ...string) (string, error) {
+	return s.addFlatpakRemote(false, param...)
+}
+
+func execAddUserFlatpakRemote(s *Set, param ...string) (string, error) {
+	return s.addFlatpakRemote(true, param...)
+}
+
+func execInstallFlatpak(s *Set, param ...string) (string, error) {
+	return s.installFlatpak(false, param...)
+}
+
+func execInstallUserFlatpak(s *Set, param ...string) (string, error) {
+	return s.installFlatpak(true, param...)
+}
+
+func execFlatpakOverride(s *Set, param ...string) (string, error) {
+	return s.overrideFlatpak(false, param...)
+}
+
+func execUserFlatpakOverride(s *Set, param ...string) (string, error) {
+	return s.overrideFlatpak(true, param...)
+}
+
+func execUpdateFlatpaks(s *Set, param ...string) (string, error) {
+	return s.updateFlatpaks(false, param...)
+}
+
+func execUpdateUserFlatpaks(s *Set, param ...string) (string, error) {
+	return s.updateFlatpaks(true, param...)
+}
+
+func execRemoveFlatpaks(s *Set, param ...string) (string, error) {
+	return s.removeFlatpaks(false, param...)
+}
+
+func execRemoveUserFlatpaks(s *Set, param ...string) (string, error) {
+	return s.removeFlatpaks(true, param...)
+}
@@ -0,0 +1,174 @@
+package run
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+var fontExts = []string{".ttf", ".otf", ".ttc", ".otc", ".woff", ".woff2"}
+
+func isFontFile(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return containsStr(fontExts, ext)
+}
+
+// sourceName derives a directory name from a download, repository or
+// file name, JetBrainsMono.zip giving JetBrainsMono
+func sourceName(src string) string {
+	name := path.Base(strings.TrimSuffix(strings.SplitN(src, "?", 2)[0], "/"))
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".tar.gz", ".tar.xz", ".tar.bz2", ".tar.zst", ".tgz", ".zip", ".git"} {
+		if strings.HasSuffix(lower, ext) {
+			return name[:len(name)-len(ext)]
+		}
+	}
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+// readFonts gets the font files of src, a font file or a .zip/.tar.gz
+// archive of the set or behind an URL, keeping the files matching the
+// optional glob patterns
+func (s *Set) readFonts(src string, checksum string, patterns []string) (map[string][]byte, error) {
+	var data []byte
+	var err error
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		data, err = fetchArchive(src)
+	} else {
+		data, err = fs.ReadFile(s.files, src)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if checksum != "" {
+		err = verifyChecksum(src, data, checksum)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var files fs.FS
+	lower := strings.ToLower(strings.SplitN(src, "?", 2)[0])
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		files, err = zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	case strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz"):
+		files, err = readTarGz(data)
+	case isFontFile(lower):
+		files = memFS{path.Base(strings.SplitN(src, "?", 2)[0]): &memFile{Data: data, Mode: 0644}}
+	default:
+		return nil, errors.New("unsupported font source " + src + ", use a font file, .zip or .tar.gz")
+	}
+	if err != nil {
+		return nil, err
+	}
+	fonts := make(map[string][]byte)
+	err = fs.WalkDir(files, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !isFontFile(name) {
+			return err
+		}
+		base := path.Base(name)
+		if len(patterns) > 0 {
+			matched := false
+			for _, p := range patterns {
+				if ok, _ := path.Match(p, base); ok {
+					matched = true
+				}
+			}
+			if !matched {
+				return nil
+			}
+		}
+		content, err := fs.ReadFile(files, name)
+		if err != nil {
+			return err
+		}
+		fonts[base] = content
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(fonts) == 0 {
+		return nil, errors.New("no font files found in " + src)
+	}
+	return fonts, nil
+}
+
+// installFonts writes the fonts of src into dir below the fontconfig
+// directory root, refreshes the cache and checks the family is visible.
+// Options: name= (directory), sha256=, match= (glob, repeatable), family=
+func (s *Set) installFonts(user bool, root string, param []string) (string, error) {
+	src := param[0]
+	opts := parseOpts(param[1:])
+
+	fonts, err := s.readFonts(src, opts.get("sha256", ""), opts["match"])
+	if err != nil {
+		return "", err
+	}
+	dir := root + "/" + opts.get("name", sourceName(src))
+	if user {
+		err = s.mkdirUser(dir)
+	} else {
+		err = os.MkdirAll(s.path(dir), 0755)
+	}
+	if err != nil {
+		return "", err
+	}
+	installed := 0
+	for name, data := range fonts {
+		file := dir + "/" + name
+		if user {
+			changed, err := s.installUserFile(file, data, 0644, false)
+			if err != nil {
+				return "", err
+			}
+			if changed {
+				installed++
+			}
+			continue
+		}
+		current, err := os.ReadFile(s.path(file))
+		if err == nil && bytes.Equal(current, data) {
+			continue
+		}
+		err = os.WriteFile(s.path(file), data, 0644)
+		if err != nil {
+			return "", err
+		}
+		installed++
+	}
+	fontconfig := s.command
+	if user {
+		fontconfig = s.userCmd
+	}
+	if installed > 0 {
+		out, err := fontconfig("fc-cache", "-f", dir).CombinedOutput()
+		if err != nil {
+			return string(out), err
+		}
+	}
+	report := editReport(installed > 0, "installed %d of %d fonts in %s", installed, len(fonts), dir)
+	if family := opts.get("family", ""); family != "" {
+		out, err := fontconfig("fc-list", ":family="+family, "family").Output()
+		if err != nil {
+			return report, err
+		}
+		if strings.TrimSpace(string(out)) == "" {
+			return report, fmt.Errorf("font family %s not found after installing %s", family, src)
+		}
+	}
+	return report, nil
+}
+
+func execInstallFonts(s *Set, param ...string) (string, error) {
+	return s.installFonts(false, "/usr/local/share/fonts", param)
+}
+
+func execInstallUserFonts(s *Set, param ...string) (string, error) {
+	return s.installFonts(true, s.home+"/.local/share/fonts", param)
+}
@@ -0,0 +1,205 @@
+package run
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ExtensionsURL is the GNOME extensions site queried for compatible releases
+var ExtensionsURL = "https://extensions.gnome.org"
+
+var shellVersionRe = regexp.MustCompile(`GNOME Shell ([0-9]+)\.([0-9]+)`)
+
+type extensionInfo struct {
+	UUID    string `json:"uuid"`
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+}
+
+// shellVersion returns the version of the installed GNOME Shell as the
+// extensions site expects it: the major number since GNOME 40, major.minor
+// before
+func (s *Set) shellVersion() (string, error) {
+	out, err := s.command("gnome-shell", "--version").Output()
+	if err != nil {
+		return "", errors.New("unable to detect the GNOME Shell version: " + err.Error())
+	}
+	m := shellVersionRe.FindStringSubmatch(string(out))
+	if m == nil {
+		return "", errors.New("unable to detect the GNOME Shell version: " + strings.TrimSpace(string(out)))
+	}
+	if m[1] == "3" {
+		return m[1] + "." + m[2], nil
+	}
+	return m[1], nil
+}
+
+// resolveExtVersion asks the extensions site for the release of uuid
+// compatible with the given shell version
+func resolveExtVersion(uuid string, shell string) (string, error) {
+	query := url.Values{}
+	query.Set("uuid", uuid)
+	query.Set("shell_version", shell)
+	resp, err := httpClient.Get(ExtensionsURL + "/extension-info/?" + query.Encode())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", fmt.Errorf("no release of %s compatible with GNOME Shell %s", uuid, shell)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unable to query extension %s: %s", uuid, resp.Status)
+	}
+	var info extensionInfo
+	err = json.NewDecoder(resp.Body).Decode(&info)
+	if err != nil {
+		return "", err
+	}
+	if info.Version == 0 {
+		return "", fmt.Errorf("no release of %s compatible with GNOME Shell %s", uuid, shell)
+	}
+	return fmt.Sprint(info.Version), nil
+}
+
+type extMetadata struct {
+	UUID         string   `json:"uuid"`
+	Name         string   `json:"name"`
+	Version      int      `json:"version"`
+	ShellVersion []string `json:"shell-version"`
+}
+
+// userDconf runs dconf as the target user, on a private bus when the user
+// is not logged in so the settings land in the user database all the same
+func (s *Set) userDconf(args ...string) *exec.Cmd {
+	if s.session().Bus != "" {
+		return s.userCmd("dconf", args...)
+	}
+	return s.userCmd("dbus-run-session", append([]string{"--", "dconf"}, args...)...)
+}
+
+func (s *Set) enabledExtensions() ([]string, error) {
+	out, err := s.userDconf("read", "/org/gnome/shell/enabled-extensions").Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseStrList(string(out)), nil
+}
+
+func (s *Set) setEnabledExtensions(list []string) (string, error) {
+	out, err := s.userDconf("write", "/org/gnome/shell/enabled-extensions", formatStrList(list)).CombinedOutput()
+	return string(out), err
+}
+
+// enableExtension adds uuid to the enabled-extensions key
+func (s *Set) enableExtension(uuid string) (string, error) {
+	enabled, err := s.enabledExtensions()
+	if err != nil {
+		return "", err
+	}
+	if containsStr(enabled, uuid) {
+		return "unchanged: " + uuid + " enabled", nil
+	}
+	out, err := s.setEnabledExtensions(append(enabled, uuid))
+	if err != nil {
+		return out, err
+	}
+	return "enabled " + uuid, nil
+}
+
+// supportsShell checks the shell-version list of an extension, where 45
+// also matches 45.2 and 3.38 matches 3.38.x
+func (m extMetadata) supportsShell(shell string) bool {
+	for _, v := range m.ShellVersion {
+		if v == shell || strings.HasPrefix(shell, v+".") || strings.HasPrefix(v, shell+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// unpackExtension installs the downloaded extension zip into the user's
+// extensions directory after checking it is meant for the installed shell
+func (s *Set) unpackExtension(uuid string, archive []byte) (string, error) {
+	r, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return "", err
+	}
+	data, err := readZipFile(r, "metadata.json")
+	if err != nil {
+		return "", fmt.Errorf("invalid extension %s: %w", uuid, err)
+	}
+	var meta extMetadata
+	err = json.Unmarshal(data, &meta)
+	if err != nil {
+		return "", fmt.Errorf("invalid metadata.json in %s: %w", uuid, err)
+	}
+	if meta.UUID != uuid {
+		return "", fmt.Errorf("extension archive holds %s instead of %s", meta.UUID, uuid)
+	}
+	shell, err := s.shellVersion()
+	if err != nil {
+		return "", err
+	}
+	if !meta.supportsShell(shell) {
+		return "", fmt.Errorf("%s supports GNOME Shell %s, not %s", uuid, strings.Join(meta.ShellVersion, ", "), shell)
+	}
+	dir := s.home + "/.local/share/gnome-shell/extensions/" + uuid
+	err = s.checkUserPath(dir)
+	if err != nil {
+		return "", err
+	}
+	err = os.RemoveAll(s.path(dir))
+	if err != nil {
+		return "", err
+	}
+	err = s.mkdirUser(dir)
+	if err != nil {
+		return "", err
+	}
+	for _, f := range r.File {
+		name := path.Clean("/" + f.Name)
+		dst := filepath.Join(dir, name)
+		if f.FileInfo().IsDir() {
+			err = s.mkdirUser(dst)
+			if err != nil {
+				return "", err
+			}
+			continue
+		}
+		err = s.mkdirUser(filepath.Dir(dst))
+		if err != nil {
+			return "", err
+		}
+		data, err := readZipFile(r, f.Name)
+		if err != nil {
+			return "", err
+		}
+		_, err = s.installUserFile(dst, data, f.Mode().Perm()|0644, false)
+		if err != nil {
+			return "", err
+		}
+	}
+	return "installed " + uuid + " version " + fmt.Sprint(meta.Version), nil
+}
+
+func readZipFile(r *zip.Reader, name string) ([]byte, error) {
+	f, err := r.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
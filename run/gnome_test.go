@@ -0,0 +1,83 @@
+package run
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// extensionSite stands in for the extension-info API, knowing dash-to-dock
+// releases for a few shell versions
+func extensionSite(t *testing.T) {
+	releases := map[string]int{"3.38": 69, "44": 84, "45": 89}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/extension-info/" {
+			http.NotFound(w, r)
+			return
+		}
+		uuid := r.URL.Query().Get("uuid")
+		if uuid != "dash-to-dock@micxgx.gmail.com" {
+			http.NotFound(w, r)
+			return
+		}
+		// the site answers without a version when no release matches
+		info := extensionInfo{UUID: uuid, Name: "Dash to Dock", Version: releases[r.URL.Query().Get("shell_version")]}
+		_ = json.NewEncoder(w).Encode(info)
+	}))
+	t.Cleanup(srv.Close)
+	saved := ExtensionsURL
+	ExtensionsURL = srv.URL
+	t.Cleanup(func() { ExtensionsURL = saved })
+}
+
+func TestResolveExtVersion(t *testing.T) {
+	extensionSite(t)
+	tests := []struct {
+		uuid    string
+		shell   string
+		version string
+		err     string
+	}{
+		{"dash-to-dock@micxgx.gmail.com", "45", "89", ""},
+		{"dash-to-dock@micxgx.gmail.com", "44", "84", ""},
+		{"dash-to-dock@micxgx.gmail.com", "3.38", "69", ""},
+		{"dash-to-dock@micxgx.gmail.com", "46", "", "no release of dash-to-dock@micxgx.gmail.com compatible with GNOME Shell 46"},
+		{"missing@example.com", "45", "", "no release of missing@example.com compatible with GNOME Shell 45"},
+	}
+	for _, tt := range tests {
+		version, err := resolveExtVersion(tt.uuid, tt.shell)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("resolveExtVersion(%s, %s) error = %v, want %q", tt.uuid, tt.shell, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("resolveExtVersion(%s, %s): %v", tt.uuid, tt.shell, err)
+			continue
+		}
+		if version != tt.version {
+			t.Errorf("resolveExtVersion(%s, %s) = %s, want %s", tt.uuid, tt.shell, version, tt.version)
+		}
+	}
+}
+
+func TestSupportsShell(t *testing.T) {
+	meta := extMetadata{ShellVersion: []string{"3.38", "44", "45.1"}}
+	tests := map[string]bool{
+		"3.38":   true,
+		"3.38.6": true,
+		"3.36":   false,
+		"44":     true,
+		"44.2":   true,
+		"45":     true,
+		"46":     false,
+	}
+	for shell, want := range tests {
+		if got := meta.supportsShell(shell); got != want {
+			t.Errorf("supportsShell(%s) = %v, want %v", shell, got, want)
+		}
+	}
+}
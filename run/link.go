@@ -0,0 +1,161 @@
+package run
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// gitTop returns the top of the git checkout holding dir and the path of
+// dir inside it. Without a checkout dir itself is the top.
+func gitTop(dir string) (string, string) {
+	top, err := filepath.Abs(dir)
+	if err != nil {
+		return dir, ""
+	}
+	for d := top; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+			rel, _ := filepath.Rel(d, top)
+			return d, rel
+		}
+		if d == filepath.Dir(d) {
+			return top, ""
+		}
+	}
+}
+
+// copyUserTree copies files into dst owned by the target user
+func (s *Set) copyUserTree(files fs.FS, dst string) error {
+	return fs.WalkDir(files, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, name)
+		if d.IsDir() {
+			return s.mkdirUser(target)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		data, err := fs.ReadFile(files, name)
+		if err != nil {
+			return err
+		}
+		_, err = s.installUserFile(target, data, info.Mode().Perm(), false)
+		return err
+	})
+}
+
+// checkoutSet keeps a copy of the set owned by the user, including its git
+// metadata so it can be updated with git pull. An existing copy is left
+// alone as it belongs to the user from then on.
+func (s *Set) checkoutSet(dest string) (string, string, error) {
+	if _, err := os.Stat(s.path(dest)); err == nil {
+		if s.DirName != "" {
+			_, rel := gitTop(s.DirName)
+			return dest, rel, nil
+		}
+		return dest, "", nil
+	}
+	if s.DirName == "" {
+		return dest, "", s.copyUserTree(s.files, dest)
+	}
+	top, rel := gitTop(s.DirName)
+	return dest, rel, s.copyUserTree(os.DirFS(top), dest)
+}
+
+// linkTree links every entry of src into dst stow-style: existing real
+// directories are descended into instead of being replaced
+func (s *Set) linkTree(src string, dst string, backup bool, report *strings.Builder) error {
+	entries, err := os.ReadDir(s.path(src))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name() == ".git" {
+			continue
+		}
+		source := filepath.Join(src, entry.Name())
+		target := filepath.Join(dst, entry.Name())
+		err := s.checkUserPath(target)
+		if err != nil {
+			return err
+		}
+		info, err := os.Lstat(s.path(target))
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			err = nil
+		case err != nil:
+			return err
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, _ := os.Readlink(s.path(target))
+			if link == source {
+				continue
+			}
+			if !backup {
+				return errors.New("conflict: " + target + " links to " + link)
+			}
+			err = os.Rename(s.path(target), s.path(target+".flechade.bak"))
+			fmt.Fprintln(report, "backed up", target)
+		case info.IsDir() && entry.IsDir():
+			err = s.linkTree(source, target, backup, report)
+			if err != nil {
+				return err
+			}
+			continue
+		default:
+			if !backup {
+				return errors.New("conflict: " + target + " already exists")
+			}
+			err = os.Rename(s.path(target), s.path(target+".flechade.bak"))
+			fmt.Fprintln(report, "backed up", target)
+		}
+		if err != nil {
+			return err
+		}
+		err = s.mkdirUser(dst)
+		if err != nil {
+			return err
+		}
+		err = os.Symlink(source, s.path(target))
+		if err != nil {
+			return err
+		}
+		err = s.chownUser(target)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(report, "linked", target)
+	}
+	return nil
+}
+
+func execLinkUserConfig(s *Set, param ...string) (string, error) {
+	srcDir := strings.Trim(param[0], "/")
+	relDir := param[1]
+	opts := parseOpts(param[2:])
+
+	name := s.Name
+	if name == "" {
+		name = "default"
+	}
+	dest := filepath.Join(s.home, opts.get("dest", ".local/share/flechade/"+name))
+	checkout, rel, err := s.checkoutSet(dest)
+	if err != nil {
+		return "", err
+	}
+	var report strings.Builder
+	src := filepath.Join(checkout, rel, srcDir)
+	err = s.linkTree(src, filepath.Join(s.home, relDir), opts.has("backup"), &report)
+	if err != nil {
+		return report.String(), err
+	}
+	if report.Len() == 0 {
+		return "unchanged " + filepath.Join(s.home, relDir), nil
+	}
+	return report.String(), nil
+}
@@ -0,0 +1,119 @@
+package run
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// memFS is a read only file system kept in memory, holding the files of an
+// unpacked archive by their slash separated path. Parent directories do not
+// need an entry of their own.
+type memFS map[string]*memFile
+
+type memFile struct {
+	Data    []byte
+	Mode    fs.FileMode
+	ModTime time.Time
+}
+
+func (m memFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	f, ok := m[name]
+	if ok && !f.Mode.IsDir() {
+		return &memOpenFile{info: memInfo{path.Base(name), f}, r: bytes.NewReader(f.Data)}, nil
+	}
+	prefix := name + "/"
+	if name == "." {
+		prefix = ""
+	}
+	children := map[string]*memFile{}
+	for key, file := range m {
+		rest, found := strings.CutPrefix(key, prefix)
+		if !found || rest == "" {
+			continue
+		}
+		child, _, nested := strings.Cut(rest, "/")
+		if nested {
+			if _, seen := children[child]; !seen {
+				children[child] = m[prefix+child]
+			}
+			continue
+		}
+		children[child] = file
+	}
+	if !ok && len(children) == 0 && name != "." {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if f == nil {
+		f = &memFile{Mode: fs.ModeDir | 0755}
+	}
+	dir := &memDir{info: memInfo{path.Base(name), f}}
+	for child, file := range children {
+		if file == nil {
+			file = &memFile{Mode: fs.ModeDir | 0755}
+		}
+		dir.entries = append(dir.entries, memInfo{child, file})
+	}
+	sort.Slice(dir.entries, func(i, j int) bool { return dir.entries[i].Name() < dir.entries[j].Name() })
+	return dir, nil
+}
+
+// memInfo describes a file of a memFS, as fs.FileInfo and fs.DirEntry
+type memInfo struct {
+	name string
+	f    *memFile
+}
+
+func (i memInfo) Name() string               { return i.name }
+func (i memInfo) Size() int64                { return int64(len(i.f.Data)) }
+func (i memInfo) Mode() fs.FileMode          { return i.f.Mode }
+func (i memInfo) Type() fs.FileMode          { return i.f.Mode.Type() }
+func (i memInfo) ModTime() time.Time         { return i.f.ModTime }
+func (i memInfo) IsDir() bool                { return i.f.Mode.IsDir() }
+func (i memInfo) Sys() any                   { return nil }
+func (i memInfo) Info() (fs.FileInfo, error) { return i, nil }
+
+type memOpenFile struct {
+	info memInfo
+	r    *bytes.Reader
+}
+
+func (f *memOpenFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memOpenFile) Read(b []byte) (int, error) { return f.r.Read(b) }
+func (f *memOpenFile) Close() error               { return nil }
+
+type memDir struct {
+	info    memInfo
+	entries []memInfo
+	offset  int
+}
+
+func (d *memDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *memDir) Close() error               { return nil }
+
+func (d *memDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+func (d *memDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	left := len(d.entries) - d.offset
+	if n > 0 && left == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && n < left {
+		left = n
+	}
+	entries := make([]fs.DirEntry, left)
+	for i := range entries {
+		entries[i] = d.entries[d.offset+i]
+	}
+	d.offset += left
+	return entries, nil
+}
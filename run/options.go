@@ -0,0 +1,33 @@
+package run
+
+import "strings"
+
+// stepOpts holds the optional key=value parameters of a step. A bare key
+// is stored with an empty value so it can be used as a switch.
+type stepOpts map[string][]string
+
+func parseOpts(params []string) stepOpts {
+	opts := make(stepOpts)
+	for _, p := range params {
+		kv := strings.SplitN(p, "=", 2)
+		value := ""
+		if len(kv) == 2 {
+			value = kv[1]
+		}
+		opts[kv[0]] = append(opts[kv[0]], value)
+	}
+	return opts
+}
+
+func (o stepOpts) has(key string) bool {
+	_, ok := o[key]
+	return ok
+}
+
+func (o stepOpts) get(key string, def string) string {
+	values, ok := o[key]
+	if !ok || values[0] == "" {
+		return def
+	}
+	return values[0]
+}
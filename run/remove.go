@@ -0,0 +1,181 @@
+package run
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// formatStrList writes a GVariant string array such as ['a', 'b']
+func formatStrList(list []string) string {
+	quoted := make([]string, len(list))
+	for i, item := range list {
+		quoted[i] = "'" + item + "'"
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// packageInstalled reports whether dpkg knows pkg, also counting the
+// configuration left behind by a removal when withConfig is set
+func (s *Set) packageInstalled(pkg string, withConfig bool) bool {
+	out, err := s.command("dpkg-query", "-W", "-f", "${Status}", pkg).Output()
+	if err != nil {
+		return false
+	}
+	status := string(out)
+	return strings.HasSuffix(status, " installed") || (withConfig && strings.HasSuffix(status, " config-files"))
+}
+
+func execRemovePackages(s *Set, param ...string) (string, error) {
+	pkgs := param[0]
+	opts := parseOpts(param[1:])
+
+	purge := opts.has("purge")
+	var plist []string
+	for _, pkg := range strings.Fields(pkgs) {
+		if s.packageInstalled(pkg, purge) {
+			plist = append(plist, pkg)
+		}
+	}
+	var out []byte
+	if len(plist) > 0 {
+		action := "remove"
+		if purge {
+			action = "purge"
+		}
+		arg := append([]string{action, "-y"}, plist...)
+		Cmd := s.command("apt", arg...)
+		Cmd.Env = os.Environ()
+		Cmd.Env = append(Cmd.Env, "DEBCONF_NONINTERACTIVE_SEEN=true")
+		Cmd.Env = append(Cmd.Env, "APT_LISTCHANGES_FRONTEND=none")
+		Cmd.Env = append(Cmd.Env, "NEEDRESTART_MODE=a")
+		Cmd.Env = append(Cmd.Env, "DEBIAN_FRONTEND=noninteractive")
+		output, err := Cmd.CombinedOutput()
+		if err != nil {
+			return string(output), err
+		}
+		out = append(out, output...)
+	}
+	if opts.has("autoremove") {
+		Cmd := s.command("apt", "autoremove", "-y")
+		Cmd.Env = os.Environ()
+		Cmd.Env = append(Cmd.Env, "DEBIAN_FRONTEND=noninteractive")
+		output, err := Cmd.CombinedOutput()
+		if err != nil {
+			return string(output), err
+		}
+		out = append(out, output...)
+	}
+	if len(plist) == 0 {
+		return "unchanged: packages not installed " + pkgs, nil
+	}
+	return string(out), nil
+}
+
+func execDisableGnomeExt(s *Set, param ...string) (string, error) {
+	ext := param[0]
+
+	enabled, err := s.enabledExtensions()
+	if err != nil {
+		return "", err
+	}
+	if !containsStr(enabled, ext) {
+		return "unchanged: " + ext + " not enabled", nil
+	}
+	var list []string
+	for _, e := range enabled {
+		if e != ext {
+			list = append(list, e)
+		}
+	}
+	out, err := s.setEnabledExtensions(list)
+	if err != nil {
+		return out, err
+	}
+	if s.hasSession() {
+		_, err = s.shellExt("DisableExtension", ext)
+		if err != nil {
+			return "", err
+		}
+	}
+	return "disabled " + ext, nil
+}
+
+func execUninstallGnomeExt(s *Set, param ...string) (string, error) {
+	ext := param[0]
+
+	out, err := execDisableGnomeExt(s, ext)
+	if err != nil {
+		return out, err
+	}
+	dir := s.home + "/.local/share/gnome-shell/extensions/" + ext
+	if err := s.checkUserPath(dir); err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(s.path(dir)); errors.Is(err, fs.ErrNotExist) {
+		return "unchanged: " + ext + " not installed", nil
+	}
+	if s.hasSession() {
+		ok, err := s.shellExt("UninstallExtension", ext)
+		if err == nil && ok == "true" {
+			return "uninstalled " + ext, nil
+		}
+	}
+	err = os.RemoveAll(s.path(dir))
+	if err != nil {
+		return "", err
+	}
+	return "uninstalled " + ext, nil
+}
+
+func execRemoveZshPlugin(s *Set, param ...string) (string, error) {
+	name := repoName(param[0])
+	opts := parseOpts(param[1:])
+
+	disabled, err := s.setZshPlugins([]string{name}, false)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	_, custom := s.ohMyZsh(opts)
+	dir := custom + "/plugins/" + name
+	if err := s.checkUserPath(dir); err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(s.path(dir)); errors.Is(err, fs.ErrNotExist) {
+		if disabled {
+			return "disabled " + name, nil
+		}
+		return "unchanged: " + name + " not installed", nil
+	}
+	err = os.RemoveAll(s.path(dir))
+	if err != nil {
+		return "", err
+	}
+	return "removed " + name, nil
+}
+
+func execRemoveFile(s *Set, param ...string) (string, error) {
+	file := param[0]
+	opts := parseOpts(param[1:])
+
+	info, err := os.Lstat(s.path(file))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "unchanged: " + file + " not found", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		if !opts.has("recursive") {
+			return "", errors.New(file + " is a directory, use recursive to remove it")
+		}
+		err = os.RemoveAll(s.path(file))
+	} else {
+		err = os.Remove(s.path(file))
+	}
+	if err != nil {
+		return "", err
+	}
+	return "removed " + file, nil
+}
@@ -0,0 +1,205 @@
+package run
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// splitSedExpr splits the fields of a sed expression at each unescaped
+// delimiter. An escaped delimiter in the pattern is the character itself,
+// quoted as it may be a regexp operator; other escapes are kept.
+func splitSedExpr(body string, delim byte) []string {
+	var parts []string
+	var field strings.Builder
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		switch {
+		case c == '\\' && i+1 < len(body) && body[i+1] == delim && len(parts) == 0:
+			field.WriteString(regexp.QuoteMeta(string(delim)))
+			i++
+		case c == '\\' && i+1 < len(body):
+			field.WriteByte(c)
+			field.WriteByte(body[i+1])
+			i++
+		case c == delim:
+			parts = append(parts, field.String())
+			field.Reset()
+		default:
+			field.WriteByte(c)
+		}
+	}
+	return append(parts, field.String())
+}
+
+// sedReplacement converts a sed replacement into a regexp template: & and
+// \1 to \9 insert the match and its groups, \& and \\ the characters
+func sedReplacement(repl string) string {
+	var out strings.Builder
+	for i := 0; i < len(repl); i++ {
+		c := repl[i]
+		switch {
+		case c == '\\' && i+1 < len(repl):
+			i++
+			switch next := repl[i]; {
+			case next >= '0' && next <= '9':
+				out.WriteString("${" + string(next) + "}")
+			case next == 'n':
+				out.WriteByte('\n')
+			case next == 't':
+				out.WriteByte('\t')
+			case next == '$':
+				out.WriteString("$$")
+			default:
+				out.WriteByte(next)
+			}
+		case c == '&':
+			out.WriteString("${0}")
+		case c == '$':
+			out.WriteString("$$")
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String()
+}
+
+// parseSedExpr converts the s/pattern/replacement/flags expressions used by
+// older sets into a RE2 pattern, applied line by line like sed does
+func parseSedExpr(expr string) (*regexp.Regexp, string, bool, error) {
+	if len(expr) < 4 || expr[0] != 's' || expr[1] == '\\' || expr[1] == '\n' {
+		return nil, "", false, errors.New("unsupported sed expression: " + expr)
+	}
+	parts := splitSedExpr(expr[2:], expr[1])
+	if len(parts) != 3 {
+		return nil, "", false, errors.New("unsupported sed expression: " + expr)
+	}
+	pattern, repl, flags := parts[0], parts[1], parts[2]
+	if strings.Contains(flags, "I") || strings.Contains(flags, "i") {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, "", false, err
+	}
+	return re, sedReplacement(repl), strings.Contains(flags, "g"), nil
+}
+
+// replaceLines applies re to each line, only to the first match of the
+// line unless global is set
+func replaceLines(content string, re *regexp.Regexp, repl string, global bool) (string, int) {
+	lines := strings.Split(content, "\n")
+	count := 0
+	for i, line := range lines {
+		matches := re.FindAllStringSubmatchIndex(line, -1)
+		if len(matches) == 0 {
+			continue
+		}
+		if global {
+			count += len(matches)
+			lines[i] = re.ReplaceAllString(line, repl)
+			continue
+		}
+		count++
+		m := matches[0]
+		expanded := re.ExpandString(nil, repl, line, m)
+		lines[i] = line[:m[0]] + string(expanded) + line[m[1]:]
+	}
+	return strings.Join(lines, "\n"), count
+}
+
+// expandFiles resolves the space separated list of files and globs.
+// Globs may match nothing, plain files have to exist.
+func (s *Set) expandFiles(list string) ([]string, error) {
+	var files []string
+	for _, pattern := range strings.Fields(list) {
+		matches, err := filepath.Glob(s.path(pattern))
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			return nil, fmt.Errorf("%s: %w", pattern, fs.ErrNotExist)
+		}
+		for _, m := range matches {
+			file := m
+			if s.Root != "" {
+				file = "/" + strings.TrimPrefix(strings.TrimPrefix(m, s.Root), "/")
+			}
+			if !containsStr(files, file) {
+				files = append(files, file)
+			}
+		}
+	}
+	return files, nil
+}
+
+// execReplace edits files with RE2 patterns. Params are either
+// pattern replacement files [required], where files is a space separated
+// list of files or globs and $1 refers to groups, or the sed style
+// expression and file of older sets.
+func execReplace(s *Set, param ...string) (string, error) {
+	var re *regexp.Regexp
+	var repl, list string
+	var err error
+	global, lineMode, required := true, false, false
+	switch {
+	case len(param) == 2:
+		re, repl, global, err = parseSedExpr(param[0])
+		lineMode = true
+		list = param[1]
+	case len(param) >= 3:
+		re, err = regexp.Compile(param[0])
+		repl = param[1]
+		list = param[2]
+		required = len(param) > 3 && param[3] == "required"
+	default:
+		return "", errors.New("invalid params: use a sed expression and files, or a pattern, a replacement and files")
+	}
+	if err != nil {
+		return "", err
+	}
+	files, err := s.expandFiles(list)
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 0 && required {
+		return "", errors.New("no files match " + list)
+	}
+	var report strings.Builder
+	total := 0
+	for _, file := range files {
+		data, err := os.ReadFile(s.path(file))
+		if err != nil {
+			return report.String(), err
+		}
+		var result string
+		var count int
+		if lineMode {
+			result, count = replaceLines(string(data), re, repl, global)
+		} else {
+			count = len(re.FindAllStringIndex(string(data), -1))
+			result = re.ReplaceAllString(string(data), repl)
+		}
+		total += count
+		fmt.Fprintf(&report, "%s: %d matches\n", file, count)
+		if result == string(data) {
+			continue
+		}
+		if s.plan {
+			report.WriteString(unifiedDiff(file, string(data), result))
+			continue
+		}
+		err = writeFileAtomic(s.path(s.resolvePath(file)), []byte(result), 0644)
+		if err != nil {
+			return report.String(), err
+		}
+	}
+	if total == 0 && required {
+		return report.String(), errors.New("pattern matched nothing: " + param[0])
+	}
+	return report.String(), nil
+}
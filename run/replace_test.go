@@ -0,0 +1,78 @@
+package run
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseSedExpr(t *testing.T) {
+	tests := []struct {
+		expr   string
+		line   string
+		want   string
+		global bool
+	}{
+		{`s/foo/bar/`, "foo foo", "bar foo", false},
+		{`s/foo/bar/g`, "foo foo", "bar bar", true},
+		{`s/FOO/bar/gI`, "foo Foo", "bar bar", true},
+		{`s/a\/b/c/`, "x a/b y", "x c y", false},
+		{`s/a/b\/c/`, "a", "b/c", false},
+		{`s|/usr/bin|/usr/local/bin|`, "/usr/bin/zsh", "/usr/local/bin/zsh", false},
+		{`s|a\|b|c|`, "a|b ab", "c ab", false},
+		{`s/world/[&]/`, "hello world", "hello [world]", false},
+		{`s/world/\&/`, "hello world", "hello &", false},
+		{`s/(h\w*) (w\w*)/\2 \1/`, "hello world", "world hello", false},
+		{`s/price/$5/`, "price", "$5", false},
+		{`s/x/a\\b/`, "x", `a\b`, false},
+	}
+	for _, tt := range tests {
+		re, repl, global, err := parseSedExpr(tt.expr)
+		if err != nil {
+			t.Errorf("parseSedExpr(%s): %v", tt.expr, err)
+			continue
+		}
+		if global != tt.global {
+			t.Errorf("parseSedExpr(%s) global = %v, want %v", tt.expr, global, tt.global)
+		}
+		got, _ := replaceLines(tt.line, re, repl, global)
+		if got != tt.want {
+			t.Errorf("%s on %q = %q, want %q", tt.expr, tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestParseSedExprInvalid(t *testing.T) {
+	for _, expr := range []string{"", "s/a", "y/a/b/", `s/a/b`, `s/a\/b/`, "s/a/b/c/d", "s/(/x/"} {
+		if _, _, _, err := parseSedExpr(expr); err == nil {
+			t.Errorf("parseSedExpr(%q) accepted an invalid expression", expr)
+		}
+	}
+}
+
+func TestReplaceFiles(t *testing.T) {
+	root := t.TempDir()
+	err := os.WriteFile(filepath.Join(root, "a.conf"), []byte("x=1\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Set{Root: root}
+	if _, err := execReplace(s, "s/1/2/", "/a.conf"); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(filepath.Join(root, "a.conf"))
+	if string(data) != "x=2\n" {
+		t.Errorf("a.conf holds %q", data)
+	}
+	if _, err := execReplace(s, "s/1/2/", "/missing.conf"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("replacing in a missing file gave %v", err)
+	}
+	if _, err := execReplace(s, "s/1/2/", "/*.missing"); err != nil {
+		t.Errorf("a glob matching nothing gave %v", err)
+	}
+	if _, err := execReplace(s, "s/1/2/"); err == nil {
+		t.Error("a single param was accepted")
+	}
+}
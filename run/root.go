@@ -0,0 +1,217 @@
+package run
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// RootDir applies the set to the filesystem mounted at that directory
+// (a disk image or a debootstrap chroot) instead of the live system
+var RootDir string
+
+type passwdEntry struct {
+	Name  string
+	Uid   string
+	Gid   string
+	Group string
+	Home  string
+	Shell string
+}
+
+// path rebases an absolute path of the target system into the root directory
+func (s *Set) path(p string) string {
+	if s.Root == "" {
+		return p
+	}
+	return filepath.Join(s.Root, p)
+}
+
+// resolvePath follows the symlinks of every component of p like the
+// kernel would inside the root directory, absolute links staying below
+// it. Missing components are kept as they are.
+func (s *Set) resolvePath(p string) string {
+	parts := strings.Split(p, "/")
+	resolved := "/"
+	links := 0
+	for i := 0; i < len(parts); i++ {
+		part := parts[i]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+		next := filepath.Join(resolved, part)
+		info, err := os.Lstat(s.path(next))
+		if err != nil || info.Mode()&fs.ModeSymlink == 0 || links >= 40 {
+			resolved = next
+			continue
+		}
+		target, err := os.Readlink(s.path(next))
+		if err != nil {
+			resolved = next
+			continue
+		}
+		links++
+		if strings.HasPrefix(target, "/") {
+			resolved = "/"
+		}
+		parts = append(strings.Split(target, "/"), parts[i+1:]...)
+		i = -1
+	}
+	return resolved
+}
+
+// command runs a program of the target system, inside a chroot when
+// working on an offline root filesystem
+func (s *Set) command(name string, args ...string) *exec.Cmd {
+	if s.Root == "" {
+		return exec.Command(name, args...)
+	}
+	return exec.Command("chroot", append([]string{s.Root, name}, args...)...)
+}
+
+// chrootMounts are the pseudo filesystems package scripts expect inside a
+// chroot, bound from the host for the time of a run
+var chrootMounts = [][]string{
+	{"/proc", "-t", "proc", "proc"},
+	{"/sys", "--rbind", "/sys"},
+	{"/dev", "--rbind", "/dev"},
+}
+
+// isMounted tells whether dir is a mount point of the host
+func isMounted(dir string) bool {
+	if real, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = real
+	}
+	data, err := os.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 4 && fields[4] == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// mountRoot prepares the root directory for running programs through
+// chroot: it mounts /proc, /sys and /dev and puts the host resolv.conf in
+// place so package steps can resolve names. The returned function undoes
+// it and has to run before exiting.
+func (s *Set) mountRoot() (func(), error) {
+	var undo []func()
+	release := func() {
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+	}
+	for _, m := range chrootMounts {
+		dir := s.path(m[0])
+		if isMounted(dir) {
+			continue
+		}
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		out, err := exec.Command("mount", append(m[1:], dir)...).CombinedOutput()
+		if err != nil {
+			release()
+			return nil, errors.New("unable to mount " + dir + ": " + strings.TrimSpace(string(out)))
+		}
+		undo = append(undo, func() {
+			if exec.Command("umount", "-R", dir).Run() != nil {
+				_ = exec.Command("umount", "-R", "-l", dir).Run()
+			}
+		})
+	}
+	restore, err := s.hostResolvConf()
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return func() {
+		restore()
+		release()
+	}, nil
+}
+
+// hostResolvConf gives the root directory the name servers of the host.
+// resolv.conf is often a link into /run, which is empty in the chroot, so
+// it is moved aside and replaced by a copy until the run ends.
+func (s *Set) hostResolvConf() (func(), error) {
+	data, err := os.ReadFile("/etc/resolv.conf")
+	if err != nil {
+		// the host has no name servers to share
+		return func() {}, nil
+	}
+	file := s.path("/etc/resolv.conf")
+	saved := file + ".flechade"
+	_, err = os.Lstat(file)
+	exists := err == nil
+	if exists {
+		err = os.Rename(file, saved)
+		if err != nil {
+			return nil, err
+		}
+	}
+	restore := func() {
+		os.Remove(file)
+		if exists {
+			_ = os.Rename(saved, file)
+		}
+	}
+	err = os.WriteFile(file, data, 0644)
+	if err != nil {
+		restore()
+		return nil, err
+	}
+	return restore, nil
+}
+
+func readPasswd(file string) ([]passwdEntry, error) {
+	var entries []passwdEntry
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 7 {
+			continue
+		}
+		entries = append(entries, passwdEntry{
+			Name:  fields[0],
+			Uid:   fields[2],
+			Gid:   fields[3],
+			Home:  fields[5],
+			Shell: fields[6],
+		})
+	}
+	return entries, scanner.Err()
+}
+
+func lookupPasswd(file string, name string) (passwdEntry, error) {
+	entries, err := readPasswd(file)
+	if err != nil {
+		return passwdEntry{}, err
+	}
+	for _, entry := range entries {
+		if entry.Name == name {
+			return entry, nil
+		}
+	}
+	return passwdEntry{}, errors.New("user not found: " + name)
+}
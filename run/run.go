@@ -8,7 +8,8 @@ import (
 	"io/fs"
 	"log"
 	"os"
-	"os/exec"
+	"os/user"
+	"strings"
 	"time"
 
 	ver "github.com/hashicorp/go-version"
@@ -17,11 +18,26 @@ import (
 )
 
 var Commands map[string]func(*Set, ...string) (string, error)
+
+// userCommands are applied once for every target user of the step
+var userCommands map[string]bool
+
+// planCommands are run in plan mode to show what they would change
+var planCommands map[string]bool
+
+// reportCommands return a summary of their changes, shown below the step
+var reportCommands map[string]bool
+
+// PlanMode shows the steps of the set and their changes without applying them
+var PlanMode bool
 var version string
 
 func init() {
 	version = "0.0.5"
 	Commands = make(map[string]func(*Set, ...string) (string, error))
+	userCommands = make(map[string]bool)
+	planCommands = make(map[string]bool)
+	reportCommands = make(map[string]bool)
 	LoadCommands()
 }
 
@@ -29,6 +45,22 @@ func SetCommand(n string, f func(*Set, ...string) (string, error)) {
 	Commands[n] = f
 }
 
+// SetPlanCommand marks a command able to preview its changes in plan mode
+func SetPlanCommand(n string) {
+	planCommands[n] = true
+}
+
+// SetReportCommand marks a command returning a summary of its changes
+// instead of the output of the programs it runs
+func SetReportCommand(n string) {
+	reportCommands[n] = true
+}
+
+func SetUserCommand(n string, f func(*Set, ...string) (string, error)) {
+	Commands[n] = f
+	userCommands[n] = true
+}
+
 type stepStat struct {
 	ErrLvl  int
 	Message string
@@ -36,23 +68,36 @@ type stepStat struct {
 
 type step struct {
 	//Id       int
-	Command  string
-	Params   []string `yaml:"params,omitempty"`
-	Desc     string
-	Status   stepStat `yaml:"status,omitempty"`
-	Complete bool     `yaml:"complete,omitempty"`
+	Command   string
+	Params    []string `yaml:"params,omitempty"`
+	Desc      string
+	Users     []string `yaml:"users,omitempty"`
+	Session   string   `yaml:"session,omitempty"`
+	Status    stepStat `yaml:"status,omitempty"`
+	Complete  bool     `yaml:"complete,omitempty"`
+	UsersDone []string `yaml:"usersdone,omitempty"`
 }
 
 type Set struct {
 	Ver         string
 	osRel       string
 	configFile  string
-	DirName     string
+	DirName     string `yaml:"dirname,omitempty"`
+	Archive     string `yaml:"-"`
+	Checksum    string `yaml:"-"`
+	Root        string `yaml:"-"`
 	files       fs.FS
 	Name        string
 	Description string
+	Users       []string `yaml:"users,omitempty"`
+	User        string   `yaml:"-"`
 	user        string
 	uid         string
+	gid         string
+	group       string
+	home        string
+	plan        bool
+	sessions    map[string]userSession
 	Steps       []step
 }
 
@@ -64,25 +109,42 @@ func NewSet(name, description string) *Set {
 	var s Set
 	home, _ := os.UserHomeDir()
 	s.configFile = home + "/.flechade"
+	s.Root = RootDir
 	s.Ver = GetVer()
 	s.Name = name
 	s.Description = description
+	_ = s.loadEnv()
+	return &s
+}
+
+func (s *Set) loadEnv() error {
 	args := []string{"-s", "-d"}
-	cmd := exec.Command("lsb_release", args...)
+	cmd := s.command("lsb_release", args...)
 	out, _ := cmd.CombinedOutput()
 	s.osRel = string(out)
 	// Get non root username
-	s.user = os.Getenv("USER")
-	sudoUser, ok := os.LookupEnv("SUDO_USER")
-	if ok {
-		s.user = sudoUser
+	name := TargetUser
+	if name == "" {
+		name = s.User
 	}
-	s.uid = os.Getenv("UID")
-	sudoUid, ok := os.LookupEnv("SUDO_UID")
-	if ok {
-		s.uid = sudoUid
+	if name == "" {
+		name = os.Getenv("USER")
+		sudoUser, ok := os.LookupEnv("SUDO_USER")
+		if ok {
+			name = sudoUser
+		}
 	}
-	return &s
+	if name == "" {
+		if current, err := user.Current(); err == nil {
+			name = current.Username
+		}
+	}
+	err := s.switchUser(name)
+	if err != nil {
+		return err
+	}
+	s.User = s.user
+	return nil
 }
 
 func (ds *Set) GetOS() string {
@@ -91,44 +153,39 @@ func (ds *Set) GetOS() string {
 
 func LoadSetFromDir(dir string) (*Set, error) {
 	var s Set
+	s.Root = RootDir
 	s.DirName = dir
 	s.files = os.DirFS(dir)
-	//s.files = dir
-	//data, err := fs.ReadDir(dir, "flachade.yaml")
+	err := s.loadFiles()
+	return &s, err
+}
+
+func (s *Set) loadFiles() error {
+	err := s.verifySignature()
+	if err != nil {
+		return err
+	}
 	yfile, err := s.files.Open("flechade.yaml")
 	if err != nil {
-		return &s, err
+		return err
 	}
+	defer yfile.Close()
 	data, err := io.ReadAll(yfile)
 	if err != nil {
-		return &s, err
+		return err
 	}
-	err = yaml.Unmarshal(data, &s)
+	err = yaml.Unmarshal(data, s)
 	if err != nil {
-		return &s, err
+		return err
 	}
 	home, _ := os.UserHomeDir()
 	s.configFile = home + "/.flechade"
 	if !s.checkVersion() {
 		err := errors.New("file version not compatible")
-		return &s, err
-	}
-	args := []string{"-s", "-d"}
-	cmd := exec.Command("lsb_release", args...)
-	out, _ := cmd.CombinedOutput()
-	s.osRel = string(out)
-	// Get non root username
-	s.user = os.Getenv("USER")
-	sudoUser, ok := os.LookupEnv("SUDO_USER")
-	if ok {
-		s.user = sudoUser
-	}
-	s.uid = os.Getenv("UID")
-	sudoUid, ok := os.LookupEnv("SUDO_UID")
-	if ok {
-		s.uid = sudoUid
+		return err
 	}
-	return &s, err
+	err = s.loadEnv()
+	return err
 }
 
 func (s *Set) checkVersion() bool {
@@ -171,26 +228,23 @@ func (s *Set) Load() error {
 	if err != nil {
 		return err
 	}
-	s.files = os.DirFS(s.DirName)
+	if s.Archive != "" {
+		s.files, s.Checksum, err = openArchive(s.Archive, s.Checksum)
+		if err != nil {
+			return err
+		}
+	} else {
+		s.files = os.DirFS(s.DirName)
+	}
+	err = s.verifySignature()
+	if err != nil {
+		return err
+	}
 	if !s.checkVersion() {
 		err = errors.New("yaml file version not compatible")
 		return err
 	}
-	args := []string{"-s", "-d"}
-	cmd := exec.Command("lsb_release", args...)
-	out, _ := cmd.CombinedOutput()
-	s.osRel = string(out)
-	// Get non root username
-	s.user = os.Getenv("USER")
-	sudoUser, ok := os.LookupEnv("SUDO_USER")
-	if ok {
-		s.user = sudoUser
-	}
-	s.uid = os.Getenv("UID")
-	sudoUid, ok := os.LookupEnv("SUDO_UID")
-	if ok {
-		s.uid = sudoUid
-	}
+	err = s.loadEnv()
 	return err
 }
 
@@ -205,7 +259,91 @@ func (s *Set) saveStats() error {
 	return err
 }
 
+// sessionSkip tells why the step can not run for the current target user:
+// session "graphical" needs the user logged into a graphical session and
+// "none" needs the user logged out
+func (ds *Set) sessionSkip(stp step) string {
+	switch stp.Session {
+	case "graphical":
+		if !ds.hasSession() {
+			return ds.user + " has no graphical session"
+		}
+	case "none":
+		if ds.hasSession() {
+			return ds.user + " is logged into a " + ds.session().String()
+		}
+	}
+	return ""
+}
+
+// printReport shows what a step changed below its status line
+func printReport(out string) {
+	out = strings.TrimSpace(out)
+	if out == "" {
+		return
+	}
+	for _, line := range strings.Split(out, "\n") {
+		fmt.Println("  " + line)
+	}
+}
+
+func (ds *Set) Plan() {
+	ds.plan = true
+	fmt.Println("Plan for the environment: " + ds.Name)
+	defaultUser := ds.user
+	for _, step := range ds.Steps {
+		if step.Complete {
+			continue
+		}
+		targets := []string{""}
+		if userCommands[step.Command] {
+			var err error
+			targets, err = ds.targetUsers(step)
+			if err != nil {
+				fmt.Println("* " + step.Desc)
+				fmt.Println("  error: " + err.Error())
+				continue
+			}
+		}
+		for _, target := range targets {
+			desc := step.Desc
+			if target != "" {
+				if containsStr(step.UsersDone, target) {
+					continue
+				}
+				if err := ds.switchUser(target); err != nil {
+					fmt.Println("* " + desc)
+					fmt.Println("  error: " + err.Error())
+					continue
+				}
+				if len(targets) > 1 {
+					desc = step.Desc + " (" + target + ")"
+				}
+			}
+			fmt.Println("* " + desc)
+			if skip := ds.sessionSkip(step); skip != "" {
+				fmt.Println("  skipped: " + skip)
+				continue
+			}
+			if !planCommands[step.Command] {
+				fmt.Printf("  would run %s %s\n", step.Command, strings.Join(step.Params, " "))
+				continue
+			}
+			out, err := Commands[step.Command](ds, step.Params...)
+			printReport(out)
+			if err != nil {
+				fmt.Println("  error: " + err.Error())
+			}
+		}
+		_ = ds.switchUser(defaultUser)
+	}
+}
+
 func (ds *Set) Run() {
+	if PlanMode {
+		ds.Plan()
+		return
+	}
 
 	if os.Geteuid() != 0 {
 		log.Fatal("This tool needs root access. Please use sudo.")
@@ -218,45 +356,106 @@ func (ds *Set) Run() {
 	var cfg yacspin.Config
 	var out string
 
+	// mounts of the root directory are released before exiting, on errors too
+	unmount := func() {}
+	fatal := func(v ...interface{}) {
+		unmount()
+		log.Fatal(v...)
+	}
+	if ds.Root != "" {
+		unmount, err = ds.mountRoot()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer unmount()
+	}
+
+	err = ds.pruneBlocks()
+	if err != nil {
+		fatal(err)
+	}
+
+	defaultUser := ds.user
+
 	for i, step := range ds.Steps {
 
 		if step.Complete {
 			continue
 		}
-		m := fmt.Sprintf("%-40s", step.Desc)[:40]
-		cfg = yacspin.Config{
-			Frequency:         100 * time.Millisecond,
-			CharSet:           yacspin.CharSets[78],
-			Suffix:            " ",
-			Prefix:            " ",
-			Colors:            []string{"fgYellow"},
-			StopMessage:       m + "	[OK]",
-			StopFailMessage:   step.Desc + "	[Failed]",
-			SuffixAutoColon:   true,
-			Message:           m,
-			StopCharacter:     "✓",
-			StopColors:        []string{"fgGreen"},
-			StopFailCharacter: "✗",
-			StopFailColors:    []string{"fgRed"},
+		pending := false
+		targets := []string{""}
+		if userCommands[step.Command] {
+			targets, err = ds.targetUsers(step)
+			if err != nil {
+				fatal(step.Desc + ": " + err.Error())
+			}
 		}
-		spinner, _ = yacspin.New(cfg)
-		_ = spinner.Start()
+		for _, target := range targets {
+			desc := step.Desc
+			if target != "" {
+				if containsStr(step.UsersDone, target) {
+					continue
+				}
+				err = ds.switchUser(target)
+				if err != nil {
+					fatal(step.Desc + ": " + err.Error())
+				}
+				if len(targets) > 1 {
+					desc = step.Desc + " (" + target + ")"
+				}
+			}
+			m := fmt.Sprintf("%-40s", desc)[:40]
+			if skip := ds.sessionSkip(step); skip != "" {
+				// left incomplete to be applied on a later run
+				pending = true
+				fmt.Printf(" - %s	[Skipped: %s]\n", m, skip)
+				continue
+			}
+			cfg = yacspin.Config{
+				Frequency:         100 * time.Millisecond,
+				CharSet:           yacspin.CharSets[78],
+				Suffix:            " ",
+				Prefix:            " ",
+				Colors:            []string{"fgYellow"},
+				StopMessage:       m + "	[OK]",
+				StopFailMessage:   desc + "	[Failed]",
+				SuffixAutoColon:   true,
+				Message:           m,
+				StopCharacter:     "✓",
+				StopColors:        []string{"fgGreen"},
+				StopFailCharacter: "✗",
+				StopFailColors:    []string{"fgRed"},
+			}
+			spinner, _ = yacspin.New(cfg)
+			_ = spinner.Start()
 
-		out, err = Commands[step.Command](ds, step.Params...)
+			out, err = Commands[step.Command](ds, step.Params...)
 
-		if err != nil {
-			step.Status.ErrLvl = 1
-			step.Status.Message = err.Error()
-			ds.Steps[i] = step
-			_ = ds.saveStats()
-			spinner.StopFailMessage(step.Desc + ": " + err.Error())
-			_ = spinner.StopFail()
-			log.Fatal(out)
-		} else {
-			step.Complete = true
-			ds.Steps[i] = step
-			_ = ds.saveStats()
+			if err != nil {
+				step.Status.ErrLvl = 1
+				step.Status.Message = err.Error()
+				ds.Steps[i] = step
+				_ = ds.saveStats()
+				spinner.StopFailMessage(desc + ": " + err.Error())
+				_ = spinner.StopFail()
+				fatal(out)
+			}
+			if target != "" {
+				step.UsersDone = append(step.UsersDone, target)
+				ds.Steps[i] = step
+				_ = ds.saveStats()
+			}
 			_ = spinner.Stop()
+			if reportCommands[step.Command] {
+				printReport(out)
+			}
+		}
+		_ = ds.switchUser(defaultUser)
+		if pending {
+			continue
 		}
+		step.Complete = true
+		ds.Steps[i] = step
+		_ = ds.saveStats()
 	}
 }
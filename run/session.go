@@ -0,0 +1,199 @@
+package run
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	logindDest = "org.freedesktop.login1"
+	logindPath = "/org/freedesktop/login1"
+)
+
+// userSession is what logind knows about the login of the target user: its
+// runtime directory and, when logged in graphically, the display session
+type userSession struct {
+	Id         string
+	Seat       string
+	Type       string
+	Display    string
+	Wayland    string
+	RuntimeDir string
+	Bus        string
+}
+
+// graphical reports whether the user runs an X11 or Wayland session
+func (us userSession) graphical() bool {
+	return us.Type == "x11" || us.Type == "wayland"
+}
+
+func (us userSession) String() string {
+	if !us.graphical() {
+		return "no graphical session"
+	}
+	desc := us.Type + " session " + us.Id
+	if us.Seat != "" {
+		desc += " on " + us.Seat
+	}
+	return desc
+}
+
+// env returns the variables programs of the user expect from their session
+func (us userSession) env() []string {
+	var env []string
+	if us.RuntimeDir != "" {
+		env = append(env, "XDG_RUNTIME_DIR="+us.RuntimeDir)
+	}
+	if us.Bus != "" {
+		env = append(env, "DBUS_SESSION_BUS_ADDRESS="+us.Bus)
+	}
+	if !us.graphical() {
+		return env
+	}
+	env = append(env, "XDG_SESSION_TYPE="+us.Type, "XDG_SESSION_ID="+us.Id)
+	if us.Seat != "" {
+		env = append(env, "XDG_SEAT="+us.Seat)
+	}
+	if us.Display != "" {
+		env = append(env, "DISPLAY="+us.Display)
+	}
+	if us.Wayland != "" {
+		env = append(env, "WAYLAND_DISPLAY="+us.Wayland)
+	}
+	return env
+}
+
+// session returns the login of the target user, discovered once per user.
+// Offline roots and users logind does not know have no session.
+func (s *Set) session() userSession {
+	if s.Root != "" {
+		return userSession{}
+	}
+	if us, ok := s.sessions[s.uid]; ok {
+		return us
+	}
+	us := lookupSession(s.uid)
+	if s.sessions == nil {
+		s.sessions = make(map[string]userSession)
+	}
+	s.sessions[s.uid] = us
+	return us
+}
+
+// hasSession reports whether the target user is logged into a graphical session
+func (s *Set) hasSession() bool {
+	return s.session().graphical()
+}
+
+// sessionEnv prefixes a command line run through su with the session variables
+func (s *Set) sessionEnv() string {
+	var prefix string
+	for _, kv := range s.session().env() {
+		k, v, _ := strings.Cut(kv, "=")
+		prefix += k + "=" + shellQuote(v) + " "
+	}
+	return prefix
+}
+
+func lookupSession(uid string) userSession {
+	var us userSession
+	id, err := strconv.ParseUint(uid, 10, 32)
+	if err != nil {
+		return us
+	}
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return us
+	}
+	var userPath dbus.ObjectPath
+	err = conn.Object(logindDest, logindPath).Call(logindDest+".Manager.GetUser", 0, uint32(id)).Store(&userPath)
+	if err != nil {
+		// not logged in
+		return us
+	}
+	user := conn.Object(logindDest, userPath)
+	if v, err := user.GetProperty(logindDest + ".User.RuntimePath"); err == nil {
+		us.RuntimeDir, _ = v.Value().(string)
+	}
+	if us.RuntimeDir != "" {
+		if _, err := os.Stat(us.RuntimeDir + "/bus"); err == nil {
+			us.Bus = "unix:path=" + us.RuntimeDir + "/bus"
+		}
+	}
+	var display struct {
+		Id   string
+		Path dbus.ObjectPath
+	}
+	v, err := user.GetProperty(logindDest + ".User.Display")
+	if err != nil || v.Store(&display) != nil || display.Path == "/" {
+		return us
+	}
+	sess := conn.Object(logindDest, display.Path)
+	props := map[string]string{}
+	for _, name := range []string{"Type", "Class", "State", "Display"} {
+		if v, err := sess.GetProperty(logindDest + ".Session." + name); err == nil {
+			props[name], _ = v.Value().(string)
+		}
+	}
+	if props["Class"] != "user" || props["State"] == "closing" {
+		return us
+	}
+	us.Id = display.Id
+	us.Type = props["Type"]
+	us.Display = props["Display"]
+	var seat struct {
+		Id   string
+		Path dbus.ObjectPath
+	}
+	if v, err := sess.GetProperty(logindDest + ".Session.Seat"); err == nil && v.Store(&seat) == nil {
+		us.Seat = seat.Id
+	}
+	if us.Type == "wayland" {
+		us.Wayland = waylandDisplay(us.RuntimeDir)
+		if us.Display == "" {
+			us.Display = xwaylandDisplay(id)
+		}
+	}
+	return us
+}
+
+// waylandDisplay finds the compositor socket in the runtime directory
+func waylandDisplay(runtimeDir string) string {
+	if runtimeDir == "" {
+		return ""
+	}
+	matches, _ := filepath.Glob(filepath.Join(runtimeDir, "wayland-*"))
+	sort.Strings(matches)
+	for _, m := range matches {
+		if strings.HasSuffix(m, ".lock") {
+			continue
+		}
+		if fi, err := os.Stat(m); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			return filepath.Base(m)
+		}
+	}
+	return ""
+}
+
+// xwaylandDisplay finds the X display the compositor of uid provides to
+// X11 clients, as logind only records displays of X11 sessions
+func xwaylandDisplay(uid uint64) string {
+	matches, _ := filepath.Glob("/tmp/.X11-unix/X*")
+	sort.Strings(matches)
+	for _, m := range matches {
+		fi, err := os.Stat(m)
+		if err != nil || fi.Mode()&os.ModeSocket == 0 {
+			continue
+		}
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok && uint64(st.Uid) == uid {
+			return ":" + strings.TrimPrefix(filepath.Base(m), "X")
+		}
+	}
+	return ""
+}
@@ -0,0 +1,280 @@
+package run
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	fisherURL = "https://raw.githubusercontent.com/jorgebucaran/fisher/main/functions/fisher.fish"
+	bashItURL = "https://github.com/Bash-it/bash-it.git"
+	bleShURL  = "https://github.com/akinomyoga/ble.sh.git"
+)
+
+// loginShells lists the shells of /etc/shells
+func (s *Set) loginShells() []string {
+	data, err := os.ReadFile(s.path("/etc/shells"))
+	if err != nil {
+		return nil
+	}
+	var shells []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !isComment(line) {
+			shells = append(shells, line)
+		}
+	}
+	return shells
+}
+
+func (s *Set) isExecutable(file string) bool {
+	info, err := os.Stat(s.path(file))
+	return err == nil && !info.IsDir() && info.Mode().Perm()&0111 != 0
+}
+
+// resolveShell finds the installed binary of shell, given as a path or a
+// name, preferring the paths registered in /etc/shells
+func (s *Set) resolveShell(shell string) (string, error) {
+	if strings.HasPrefix(shell, "/") {
+		if !s.isExecutable(shell) {
+			return "", fmt.Errorf("%s is not installed", shell)
+		}
+		return shell, nil
+	}
+	candidates := []string{}
+	for _, registered := range s.loginShells() {
+		if filepath.Base(registered) == shell {
+			candidates = append(candidates, registered)
+		}
+	}
+	for _, dir := range []string{"/usr/bin", "/bin", "/usr/local/bin"} {
+		candidates = append(candidates, dir+"/"+shell)
+	}
+	for _, candidate := range candidates {
+		if s.isExecutable(candidate) {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("%s is not installed", shell)
+}
+
+// execSetShell changes the login shell of the target user. Shells missing
+// from /etc/shells are refused unless the register option adds them.
+func execSetShell(s *Set, param ...string) (string, error) {
+	opts := parseOpts(param[1:])
+
+	shell, err := s.resolveShell(param[0])
+	if err != nil {
+		return "", err
+	}
+	var report []string
+	if !containsStr(s.loginShells(), shell) {
+		if !opts.has("register") {
+			return "", fmt.Errorf("%s is not listed in /etc/shells, use register to add it", shell)
+		}
+		out, err := execEnsureLine(s, "/etc/shells", shell)
+		if err != nil {
+			return out, err
+		}
+		report = append(report, out)
+	}
+	entry, err := lookupPasswd(s.path("/etc/passwd"), s.user)
+	if err != nil {
+		return "", err
+	}
+	if entry.Shell == shell {
+		report = append(report, "unchanged: login shell of "+s.user+" is "+shell)
+		return strings.Join(report, "\n"), nil
+	}
+	out, err := s.command("usermod", "-s", shell, s.user).CombinedOutput()
+	if err != nil {
+		return string(out), err
+	}
+	report = append(report, "login shell of "+s.user+" set to "+shell)
+	return strings.Join(report, "\n"), nil
+}
+
+// execEnableZsh is kept for sets written before SetShell
+func execEnableZsh(s *Set, param ...string) (string, error) {
+	return execSetShell(s, "zsh")
+}
+
+// fishPlugins lists the plugins fisher keeps in fish_plugins
+func (s *Set) fishPlugins() []string {
+	data, err := os.ReadFile(s.path(s.home + "/.config/fish/fish_plugins"))
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(string(data))
+}
+
+func (s *Set) fish(cmdline string) error {
+	out, err := s.userCmd("fish", "-c", cmdline).CombinedOutput()
+	if err != nil {
+		return errors.New(strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// execInstallFishPlugins installs fisher plugins, bootstrapping fisher
+// itself when the user does not have it yet
+func execInstallFishPlugins(s *Set, param ...string) (string, error) {
+	plugins := strings.Fields(param[0])
+
+	var report []string
+	if !containsStr(s.fishPlugins(), "jorgebucaran/fisher") {
+		err := s.fish("curl -sL " + fisherURL + " | source && fisher install jorgebucaran/fisher")
+		if err != nil {
+			return "", fmt.Errorf("unable to install fisher: %w", err)
+		}
+		report = append(report, "installed fisher")
+	}
+	installed := s.fishPlugins()
+	var missing []string
+	for _, p := range plugins {
+		if !containsStr(installed, p) {
+			missing = append(missing, p)
+		}
+	}
+	if len(missing) > 0 {
+		err := s.fish("fisher install " + shellQuote(missing...))
+		if err != nil {
+			return strings.Join(report, "\n"), err
+		}
+	}
+	report = append(report, editReport(len(missing) > 0, "installed %s", strings.Join(plugins, " ")))
+	return strings.Join(report, "\n"), nil
+}
+
+func execRemoveFishPlugins(s *Set, param ...string) (string, error) {
+	plugins := strings.Fields(param[0])
+
+	installed := s.fishPlugins()
+	var present []string
+	for _, p := range plugins {
+		if containsStr(installed, p) {
+			present = append(present, p)
+		}
+	}
+	if len(present) > 0 {
+		err := s.fish("fisher remove " + shellQuote(present...))
+		if err != nil {
+			return "", err
+		}
+	}
+	return editReport(len(present) > 0, "removed %s", strings.Join(plugins, " ")), nil
+}
+
+// bashItDir returns where bash-it lives for the target user
+func (s *Set) bashItDir(opts stepOpts) string {
+	return opts.get("dir", s.home+"/.bash_it")
+}
+
+// execInstallBashIt clones bash-it and loads it from a managed block of
+// .bashrc instead of running its installer, which replaces the file
+func execInstallBashIt(s *Set, param ...string) (string, error) {
+	opts := parseOpts(param)
+
+	dir := s.bashItDir(opts)
+	cloned, err := s.cloneUserRepo(opts.get("repo", bashItURL), dir)
+	if err != nil {
+		return "", err
+	}
+	content := []string{"export BASH_IT=" + shellQuote(dir)}
+	if opts.has("theme") {
+		content = append(content, "export BASH_IT_THEME="+shellQuote(opts.get("theme", "")))
+	}
+	content = append(content, `source "$BASH_IT/bash_it.sh"`)
+	b := managedBlock{File: s.home + "/.bashrc", Id: "bash-it", Prefix: "#"}
+	changed, err := s.editLines(b.File, true, func(lines []string) []string {
+		return setBlock(lines, b, content)
+	})
+	if err != nil {
+		return "", err
+	}
+	return editReport(cloned || changed, "installed bash-it in %s", dir), s.chownUser(b.File)
+}
+
+// bashItSuffix maps the component kinds of bash-it to their file suffix
+var bashItSuffix = map[string]string{
+	"plugin":     ".plugin.bash",
+	"alias":      ".aliases.bash",
+	"completion": ".completion.bash",
+}
+
+// execBashItEnable enables bash-it plugins, aliases or completions:
+// kind "names..."
+func execBashItEnable(s *Set, param ...string) (string, error) {
+	kind := param[0]
+	names := strings.Fields(param[1])
+	opts := parseOpts(param[2:])
+
+	suffix, ok := bashItSuffix[kind]
+	if !ok {
+		return "", errors.New("unknown bash-it component " + kind + ", use plugin, alias or completion")
+	}
+	dir := s.bashItDir(opts)
+	var missing []string
+	for _, name := range names {
+		matches, _ := filepath.Glob(s.path(dir + "/enabled/*---" + name + suffix))
+		if len(matches) == 0 {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		cmdline := "export BASH_IT=" + shellQuote(dir) + ` && source "$BASH_IT/bash_it.sh" && bash-it enable ` +
+			kind + " " + shellQuote(missing...)
+		out, err := s.userCmd("bash", "-c", cmdline).CombinedOutput()
+		if err != nil {
+			return string(out), err
+		}
+	}
+	return editReport(len(missing) > 0, "enabled %s %s", kind, strings.Join(names, " ")), nil
+}
+
+// execInstallBleSh builds ble.sh into ~/.local and hooks it into .bashrc,
+// where it has to be sourced first and attached last
+func execInstallBleSh(s *Set, param ...string) (string, error) {
+	opts := parseOpts(param)
+
+	prefix := s.home + "/.local"
+	installed := false
+	if _, err := os.Stat(s.path(prefix + "/share/blesh/ble.sh")); err != nil {
+		src := s.home + "/.local/src/ble.sh"
+		if _, err := os.Stat(s.path(src)); err != nil {
+			err = s.mkdirUser(filepath.Dir(src))
+			if err != nil {
+				return "", err
+			}
+			out, err := s.userCmd("git", "clone", "--recursive", "--depth=1", "--shallow-submodules",
+				opts.get("repo", bleShURL), src).CombinedOutput()
+			if err != nil {
+				return string(out), err
+			}
+		}
+		out, err := s.userCmd("make", "-C", src, "install", "PREFIX="+prefix).CombinedOutput()
+		if err != nil {
+			return string(out), err
+		}
+		installed = true
+	}
+	file := s.home + "/.bashrc"
+	load := managedBlock{File: file, Id: "blesh", Prefix: "#"}
+	attach := managedBlock{File: file, Id: "blesh-attach", Prefix: "#"}
+	changed, err := s.editLines(file, true, func(lines []string) []string {
+		begin, _ := load.markers()
+		if !containsStr(lines, begin) {
+			lines = append(setBlock(nil, load, []string{`[[ $- == *i* ]] && source ` + shellQuote(prefix+"/share/blesh/ble.sh") + ` --noattach`}), lines...)
+		}
+		// attaching has to stay the last thing .bashrc does
+		lines = setBlock(setBlock(lines, attach, nil), attach, []string{`[[ ! ${BLE_VERSION-} ]] || ble-attach`})
+		return lines
+	})
+	if err != nil {
+		return "", err
+	}
+	return editReport(installed || changed, "installed ble.sh in %s", prefix), s.chownUser(file)
+}
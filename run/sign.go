@@ -0,0 +1,259 @@
+package run
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	sumsFile      = "flechade.sums"
+	sigFile       = "flechade.sums.sig"
+	sigNamespace  = "flechade"
+	sshSigMagic   = "SSHSIG"
+	sshSigArmorHd = "-----BEGIN SSH SIGNATURE-----"
+	sshSigArmorFt = "-----END SSH SIGNATURE-----"
+)
+
+// SignatureRequired refuses to load sets without a valid signature
+var SignatureRequired bool
+
+// TrustStore lists the public keys allowed to sign sets, one per line in
+// authorized_keys or allowed_signers format
+var TrustStore = "/etc/flechade/allowed_signers"
+
+type sshSig struct {
+	Version   uint32
+	PublicKey []byte
+	Namespace string
+	Reserved  string
+	HashAlg   string
+	Signature []byte
+}
+
+type sshSigData struct {
+	Namespace string
+	Reserved  string
+	HashAlg   string
+	Hash      []byte
+}
+
+// WriteManifest creates the flechade.sums file for the set in dir. The
+// manifest is then signed with:
+//
+//	ssh-keygen -Y sign -f <key> -n flechade flechade.sums
+func WriteManifest(dir string) error {
+	sums, err := hashFiles(os.DirFS(dir))
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(sums))
+	for name := range sums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s  %s\n", sums[name], name)
+	}
+	return os.WriteFile(filepath.Join(dir, sumsFile), buf.Bytes(), 0644)
+}
+
+func hashFiles(files fs.FS) (map[string]string, error) {
+	sums := make(map[string]string)
+	err := fs.WalkDir(files, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path == ".git" {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if path == sumsFile || path == sigFile {
+			return nil
+		}
+		f, err := files.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		h := sha256.New()
+		_, err = io.Copy(h, f)
+		if err != nil {
+			return err
+		}
+		sums[path] = hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+	return sums, err
+}
+
+func (s *Set) verifySignature() error {
+	manifest, err := fs.ReadFile(s.files, sumsFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		if SignatureRequired {
+			return errors.New("set is not signed: " + sumsFile + " not found")
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	armored, err := fs.ReadFile(s.files, sigFile)
+	if err != nil {
+		return fmt.Errorf("unable to read set signature: %w", err)
+	}
+	keys, err := loadTrustStore(TrustStore)
+	if err != nil {
+		return err
+	}
+	err = verifySSHSig(manifest, armored, keys)
+	if err != nil {
+		return err
+	}
+	return checkManifest(s.files, manifest)
+}
+
+func loadTrustStore(file string) ([]ssh.PublicKey, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read trust store: %w", err)
+	}
+	defer f.Close()
+	var keys []ssh.PublicKey
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			// allowed_signers lines start with the principal
+			fields := strings.SplitN(line, " ", 2)
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("invalid key in trust store: %s", line)
+			}
+			key, _, _, _, err = ssh.ParseAuthorizedKey([]byte(fields[1]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid key in trust store: %w", err)
+			}
+		}
+		keys = append(keys, key)
+	}
+	return keys, scanner.Err()
+}
+
+func verifySSHSig(message []byte, armored []byte, trusted []ssh.PublicKey) error {
+	text := strings.TrimSpace(string(armored))
+	if !strings.HasPrefix(text, sshSigArmorHd) || !strings.HasSuffix(text, sshSigArmorFt) {
+		return errors.New("invalid signature format")
+	}
+	text = strings.TrimSuffix(strings.TrimPrefix(text, sshSigArmorHd), sshSigArmorFt)
+	blob, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), ""))
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(blob, []byte(sshSigMagic)) {
+		return errors.New("invalid signature format")
+	}
+	var sig sshSig
+	err = ssh.Unmarshal(blob[len(sshSigMagic):], &sig)
+	if err != nil {
+		return err
+	}
+	if sig.Version != 1 || sig.Namespace != sigNamespace {
+		return errors.New("signature was not made for flechade sets")
+	}
+	pub, err := ssh.ParsePublicKey(sig.PublicKey)
+	if err != nil {
+		return err
+	}
+	if !isTrusted(pub, trusted) {
+		return errors.New("set signed by untrusted key " + ssh.FingerprintSHA256(pub))
+	}
+	var h hash.Hash
+	switch sig.HashAlg {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return errors.New("unsupported signature hash " + sig.HashAlg)
+	}
+	h.Write(message)
+	signed := append([]byte(sshSigMagic), ssh.Marshal(sshSigData{
+		Namespace: sig.Namespace,
+		Reserved:  sig.Reserved,
+		HashAlg:   sig.HashAlg,
+		Hash:      h.Sum(nil),
+	})...)
+	var signature ssh.Signature
+	err = ssh.Unmarshal(sig.Signature, &signature)
+	if err != nil {
+		return err
+	}
+	err = pub.Verify(signed, &signature)
+	if err != nil {
+		return errors.New("invalid set signature")
+	}
+	return nil
+}
+
+func isTrusted(pub ssh.PublicKey, trusted []ssh.PublicKey) bool {
+	for _, key := range trusted {
+		if bytes.Equal(key.Marshal(), pub.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+func checkManifest(files fs.FS, manifest []byte) error {
+	want := make(map[string]string)
+	for _, line := range strings.Split(string(manifest), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.SplitN(line, "  ", 2)
+		if len(fields) != 2 {
+			return errors.New("invalid manifest line: " + line)
+		}
+		want[fields[1]] = fields[0]
+	}
+	got, err := hashFiles(files)
+	if err != nil {
+		return err
+	}
+	for name, sum := range got {
+		expected, ok := want[name]
+		if !ok {
+			return errors.New("file not listed in signed manifest: " + name)
+		}
+		if expected != sum {
+			return errors.New("file modified after signing: " + name)
+		}
+	}
+	for name := range want {
+		if _, ok := got[name]; !ok {
+			return errors.New("file missing from set: " + name)
+		}
+	}
+	return nil
+}
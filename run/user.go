@@ -0,0 +1,201 @@
+package run
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// TargetUser overrides the user customized by user-scoped steps, which
+// defaults to the one running sudo
+var TargetUser string
+
+// switchUser makes name the target of user-scoped steps, resolving its ids,
+// primary group and home directory through the passwd database
+func (s *Set) switchUser(name string) error {
+	if name == "" {
+		return errors.New("unable to determine the target user, please use -user")
+	}
+	if name == s.user && s.home != "" {
+		return nil
+	}
+	var entry passwdEntry
+	var err error
+	if s.Root != "" {
+		// users of the image, not of the live system
+		entry, err = lookupPasswd(s.path("/etc/passwd"), name)
+		if err == nil {
+			entry.Group, err = lookupGroupName(s.path("/etc/group"), entry.Gid)
+		}
+	} else {
+		entry, err = lookupUser(name)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to resolve target user %s: %w", name, err)
+	}
+	if entry.Home == "" {
+		return fmt.Errorf("target user %s has no home directory", name)
+	}
+	s.user = entry.Name
+	s.uid = entry.Uid
+	s.gid = entry.Gid
+	s.group = entry.Group
+	s.home = entry.Home
+	return nil
+}
+
+func lookupUser(name string) (passwdEntry, error) {
+	var entry passwdEntry
+	u, err := user.Lookup(name)
+	if err != nil {
+		return entry, err
+	}
+	g, err := user.LookupGroupId(u.Gid)
+	if err != nil {
+		return entry, err
+	}
+	entry.Name = u.Username
+	entry.Uid = u.Uid
+	entry.Gid = u.Gid
+	entry.Group = g.Name
+	entry.Home = u.HomeDir
+	return entry, nil
+}
+
+func lookupGroupName(groupFile string, gid string) (string, error) {
+	data, err := os.ReadFile(groupFile)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) >= 3 && fields[2] == gid {
+			return fields[0], nil
+		}
+	}
+	return "", errors.New("group not found: " + gid)
+}
+
+// groupMembers lists the users having group as primary or supplementary group
+func groupMembers(passwdFile string, groupFile string, group string) ([]string, error) {
+	data, err := os.ReadFile(groupFile)
+	if err != nil {
+		return nil, err
+	}
+	var members []string
+	gid := ""
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 4 || fields[0] != group {
+			continue
+		}
+		gid = fields[2]
+		for _, m := range strings.Split(fields[3], ",") {
+			if m != "" {
+				members = append(members, m)
+			}
+		}
+	}
+	if gid == "" {
+		return nil, errors.New("group not found: " + group)
+	}
+	entries, err := readPasswd(passwdFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.Gid == gid && !containsStr(members, entry.Name) {
+			members = append(members, entry.Name)
+		}
+	}
+	return members, nil
+}
+
+// humanUsers lists the regular login accounts (UID >= 1000)
+func humanUsers(passwdFile string) ([]string, error) {
+	entries, err := readPasswd(passwdFile)
+	if err != nil {
+		return nil, err
+	}
+	var users []string
+	for _, entry := range entries {
+		uid, err := strconv.Atoi(entry.Uid)
+		if err != nil || uid < 1000 || uid == 65534 {
+			continue
+		}
+		if strings.HasSuffix(entry.Shell, "nologin") || strings.HasSuffix(entry.Shell, "false") {
+			continue
+		}
+		users = append(users, entry.Name)
+	}
+	return users, nil
+}
+
+// targetUsers resolves the users a step applies to. Selectors come from the
+// step or the set: a user name, @group for its members or * for every human
+// user. Without selectors the user running sudo is the target.
+func (s *Set) targetUsers(stp step) ([]string, error) {
+	selectors := stp.Users
+	if len(selectors) == 0 {
+		selectors = s.Users
+	}
+	if len(selectors) == 0 {
+		return []string{s.user}, nil
+	}
+	var users []string
+	for _, sel := range selectors {
+		var names []string
+		var err error
+		switch {
+		case sel == "*":
+			names, err = humanUsers(s.path("/etc/passwd"))
+		case strings.HasPrefix(sel, "@"):
+			names, err = groupMembers(s.path("/etc/passwd"), s.path("/etc/group"), sel[1:])
+		default:
+			names = []string{sel}
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if !containsStr(users, name) {
+				users = append(users, name)
+			}
+		}
+	}
+	if len(users) == 0 {
+		return nil, errors.New("no target users found")
+	}
+	return users, nil
+}
+
+func containsStr(list []string, str string) bool {
+	for _, item := range list {
+		if item == str {
+			return true
+		}
+	}
+	return false
+}
+
+// shellQuote quotes args for the shell started by su
+func shellQuote(args ...string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+// userCmd runs a program as the target user in the environment of its
+// session. Without root, as when capturing, it runs as the current user.
+func (s *Set) userCmd(name string, args ...string) *exec.Cmd {
+	if os.Geteuid() != 0 {
+		return s.command(name, args...)
+	}
+	return s.command("su", s.user, "-c", s.sessionEnv()+shellQuote(append([]string{name}, args...)...))
+}
@@ -0,0 +1,265 @@
+package run
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/template"
+)
+
+type templateData struct {
+	User     string
+	Uid      string
+	Gid      string
+	Group    string
+	Home     string
+	Hostname string
+}
+
+// ids returns the numeric uid and gid of the target user
+func (s *Set) ids() (int, int, error) {
+	uid, err := strconv.Atoi(s.uid)
+	if err != nil {
+		return 0, 0, errors.New("invalid uid for " + s.user)
+	}
+	gid, err := strconv.Atoi(s.gid)
+	if err != nil {
+		return 0, 0, errors.New("invalid gid for " + s.user)
+	}
+	return uid, gid, nil
+}
+
+// checkUserPath refuses paths whose parent directories lead out of the
+// home directory. Users control the links in their home, root would
+// otherwise create, write or remove files wherever they point.
+func (s *Set) checkUserPath(file string) error {
+	home := s.resolvePath(s.home)
+	dir := s.resolvePath(filepath.Dir(file))
+	if !inDir(filepath.Join(dir, filepath.Base(file)), home) {
+		return fmt.Errorf("refusing to write %s, it points outside of %s", file, s.home)
+	}
+	return nil
+}
+
+// chownUser gives file to the target user without following symlinks
+func (s *Set) chownUser(file string) error {
+	uid, gid, err := s.ids()
+	if err != nil {
+		return err
+	}
+	err = s.checkUserPath(file)
+	if err != nil {
+		return err
+	}
+	return os.Lchown(s.path(file), uid, gid)
+}
+
+// mkdirUser creates dir and its missing parents owned by the target user
+func (s *Set) mkdirUser(dir string) error {
+	err := s.checkUserPath(dir)
+	if err != nil {
+		return err
+	}
+	return s.mkdirAllUser(dir)
+}
+
+func (s *Set) mkdirAllUser(dir string) error {
+	if _, err := os.Stat(s.path(dir)); err == nil {
+		return nil
+	}
+	err := s.mkdirAllUser(filepath.Dir(dir))
+	if err != nil {
+		return err
+	}
+	err = os.Mkdir(s.path(dir), 0755)
+	if err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	return s.chownUser(dir)
+}
+
+// declaredMode returns the mode given to name with mode=<glob>:<octal>
+func declaredMode(opts stepOpts, name string, def fs.FileMode) (fs.FileMode, error) {
+	for _, decl := range opts["mode"] {
+		kv := strings.SplitN(decl, ":", 2)
+		if len(kv) != 2 {
+			return def, errors.New("invalid mode option: " + decl)
+		}
+		match, err := path.Match(kv[0], name)
+		if err != nil {
+			return def, err
+		}
+		if !match {
+			match, _ = path.Match(kv[0], path.Base(name))
+		}
+		if match {
+			mode, err := strconv.ParseUint(kv[1], 8, 32)
+			if err != nil {
+				return def, errors.New("invalid mode option: " + decl)
+			}
+			return fs.FileMode(mode), nil
+		}
+	}
+	return def, nil
+}
+
+// hostname returns the name of the target system, which is not the
+// running host when working on an offline root
+func (s *Set) hostname() string {
+	data, err := os.ReadFile(s.path("/etc/hostname"))
+	if err == nil && strings.TrimSpace(string(data)) != "" {
+		return strings.TrimSpace(string(data))
+	}
+	if s.Root != "" {
+		return ""
+	}
+	host, _ := os.Hostname()
+	return host
+}
+
+func (s *Set) expandTemplate(name string, data []byte) ([]byte, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(string(data))
+	if err != nil {
+		return nil, err
+	}
+	host := s.hostname()
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, templateData{
+		User:     s.user,
+		Uid:      s.uid,
+		Gid:      s.gid,
+		Group:    s.group,
+		Home:     s.home,
+		Hostname: host,
+	})
+	return buf.Bytes(), err
+}
+
+// installUserFile writes data into the user's home reporting whether
+// anything changed. Replaced files are kept as .flechade.bak on request.
+// Symlinks are refused: root would otherwise write wherever the user
+// points them.
+func (s *Set) installUserFile(dst string, data []byte, mode fs.FileMode, backup bool) (bool, error) {
+	uid, gid, err := s.ids()
+	if err != nil {
+		return false, err
+	}
+	err = s.checkUserPath(dst)
+	if err != nil {
+		return false, err
+	}
+	current, err := os.OpenFile(s.path(dst), os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if errors.Is(err, syscall.ELOOP) {
+		return false, errors.New("refusing to write through symlink " + dst)
+	}
+	exists := err == nil
+	if exists {
+		content, err := io.ReadAll(current)
+		info, statErr := current.Stat()
+		current.Close()
+		if err != nil {
+			return false, err
+		}
+		if bytes.Equal(content, data) && statErr == nil && info.Mode().Perm() == mode.Perm() {
+			return false, nil
+		}
+		if backup && !bytes.Equal(content, data) {
+			err = os.Rename(s.path(dst), s.path(dst+".flechade.bak"))
+			if err != nil {
+				return false, err
+			}
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+	f, err := os.OpenFile(s.path(dst), os.O_WRONLY|os.O_CREATE|os.O_TRUNC|syscall.O_NOFOLLOW, mode.Perm())
+	if errors.Is(err, syscall.ELOOP) {
+		return false, errors.New("refusing to write through symlink " + dst)
+	}
+	if err != nil {
+		return false, err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		// OpenFile keeps the mode of existing files
+		err = f.Chmod(mode)
+	}
+	if err == nil {
+		err = f.Chown(uid, gid)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return true, err
+}
+
+func execInstallUserDir(s *Set, param ...string) (string, error) {
+	srcDir := strings.Trim(param[0], "/")
+	if srcDir == "" {
+		srcDir = "."
+	}
+	relDir := param[1]
+	opts := parseOpts(param[2:])
+
+	dstRoot := filepath.Join(s.home, relDir)
+	expand := opts.has("template")
+	backup := opts.has("backup")
+	var report strings.Builder
+
+	err := fs.WalkDir(s.files, srcDir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(srcDir, name)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(dstRoot, rel)
+		if d.IsDir() {
+			return s.mkdirUser(dst)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		data, err := fs.ReadFile(s.files, name)
+		if err != nil {
+			return err
+		}
+		if expand && strings.HasSuffix(name, ".tmpl") {
+			data, err = s.expandTemplate(name, data)
+			if err != nil {
+				return err
+			}
+			dst = strings.TrimSuffix(dst, ".tmpl")
+			rel = strings.TrimSuffix(rel, ".tmpl")
+		}
+		mode, err := declaredMode(opts, rel, info.Mode().Perm()|0600)
+		if err != nil {
+			return err
+		}
+		changed, err := s.installUserFile(dst, data, mode, backup)
+		if err != nil {
+			return err
+		}
+		if changed {
+			fmt.Fprintln(&report, "installed", dst)
+		}
+		return nil
+	})
+	if err != nil {
+		return report.String(), err
+	}
+	if report.Len() == 0 {
+		return "unchanged " + dstRoot, nil
+	}
+	return report.String(), nil
+}
@@ -0,0 +1,291 @@
+package run
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var zshRefRe = regexp.MustCompile(`\$\{ZSH\}|\$ZSH\b`)
+
+// zshVar reads a variable assigned in a shell file, expanding the home
+// directory references oh-my-zsh templates use
+func (s *Set) zshVar(lines []string, key string) string {
+	for _, line := range lines {
+		trimmed := strings.TrimPrefix(strings.TrimSpace(line), "export ")
+		if !strings.HasPrefix(trimmed, key+"=") {
+			continue
+		}
+		value := strings.Trim(strings.TrimPrefix(trimmed, key+"="), `"'`)
+		value = strings.ReplaceAll(value, "${HOME}", s.home)
+		value = strings.ReplaceAll(value, "$HOME", s.home)
+		if value == "~" || strings.HasPrefix(value, "~/") {
+			value = s.home + value[1:]
+		}
+		return value
+	}
+	return ""
+}
+
+func (s *Set) zshrcLines() []string {
+	data, err := os.ReadFile(s.path(s.home + "/.zshrc"))
+	if err != nil {
+		return nil
+	}
+	return strings.Split(string(data), "\n")
+}
+
+// ohMyZsh locates the oh-my-zsh install of the target user and its custom
+// folder: the zsh= option first, then the ZSH and ZSH_CUSTOM variables of
+// .zshrc and last the installer default ~/.oh-my-zsh
+func (s *Set) ohMyZsh(opts stepOpts) (string, string) {
+	lines := s.zshrcLines()
+	zsh := opts.get("zsh", s.zshVar(lines, "ZSH"))
+	if zsh == "" {
+		zsh = s.home + "/.oh-my-zsh"
+	}
+	// templates set ZSH_CUSTOM=$ZSH/custom
+	custom := zshRefRe.ReplaceAllLiteralString(s.zshVar(lines, "ZSH_CUSTOM"), zsh)
+	if custom == "" {
+		custom = zsh + "/custom"
+	}
+	return zsh, custom
+}
+
+// isOmzSource matches the line of .zshrc loading oh-my-zsh, before which
+// plugins and theme have to be set
+func isOmzSource(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return (strings.HasPrefix(trimmed, "source ") || strings.HasPrefix(trimmed, ". ")) &&
+		strings.HasSuffix(strings.Trim(trimmed, `"'`), "oh-my-zsh.sh")
+}
+
+// insertBeforeOmz adds line right before oh-my-zsh is loaded, or at the end
+// of files not loading it
+func insertBeforeOmz(lines []string, line string) []string {
+	for i, l := range lines {
+		if isOmzSource(l) {
+			result := append([]string{}, lines[:i]...)
+			result = append(result, line)
+			return append(result, lines[i:]...)
+		}
+	}
+	return append(lines, line)
+}
+
+// cutComment splits a shell line at the # starting a comment
+func cutComment(line string) (string, string) {
+	for i, c := range line {
+		if c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t') {
+			return line[:i], line[i:]
+		}
+	}
+	return line, ""
+}
+
+// editZshPlugins applies edit to the plugins=(...) array of a .zshrc,
+// which may span several lines and carry comments
+func editZshPlugins(lines []string, edit func([]string) []string) []string {
+	start := -1
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "plugins=(") {
+			start = i
+			break
+		}
+	}
+	end := -1
+	var current []string
+	var tail string
+	for i := start; start >= 0 && i < len(lines); i++ {
+		text := lines[i]
+		if i == start {
+			text = text[strings.Index(text, "(")+1:]
+		}
+		body, comment := cutComment(text)
+		if j := strings.Index(body, ")"); j >= 0 {
+			current = append(current, strings.Fields(body[:j])...)
+			end, tail = i, body[j+1:]+comment
+			break
+		}
+		current = append(current, strings.Fields(body)...)
+	}
+	if end < 0 {
+		plugins := edit(nil)
+		if len(plugins) == 0 {
+			return lines
+		}
+		return insertBeforeOmz(lines, "plugins=("+strings.Join(plugins, " ")+")")
+	}
+	plugins := edit(current)
+	if strings.Join(plugins, " ") == strings.Join(current, " ") {
+		return lines
+	}
+	indent := lines[start][:strings.Index(lines[start], "plugins=(")]
+	var replaced []string
+	if start == end {
+		replaced = []string{indent + "plugins=(" + strings.Join(plugins, " ") + ")" + tail}
+	} else {
+		replaced = append(replaced, indent+"plugins=(")
+		for _, p := range plugins {
+			replaced = append(replaced, indent+"  "+p)
+		}
+		replaced = append(replaced, indent+")"+tail)
+	}
+	result := append([]string{}, lines[:start]...)
+	result = append(result, replaced...)
+	return append(result, lines[end+1:]...)
+}
+
+// setZshPlugins adds or removes plugin names in the user's .zshrc
+func (s *Set) setZshPlugins(names []string, enable bool) (bool, error) {
+	return s.editLines(s.home+"/.zshrc", false, func(lines []string) []string {
+		return editZshPlugins(lines, func(plugins []string) []string {
+			var result []string
+			for _, p := range plugins {
+				if enable || !containsStr(names, p) {
+					result = append(result, p)
+				}
+			}
+			for _, name := range names {
+				if enable && !containsStr(result, name) {
+					result = append(result, name)
+				}
+			}
+			return result
+		})
+	})
+}
+
+// setZshTheme sets ZSH_THEME in the user's .zshrc
+func (s *Set) setZshTheme(theme string) (bool, error) {
+	value := `"` + theme + `"`
+	return s.editLines(s.home+"/.zshrc", false, func(lines []string) []string {
+		for _, line := range lines {
+			if strings.HasPrefix(strings.TrimSpace(line), "ZSH_THEME=") {
+				return setShellVar(lines, "ZSH_THEME", &value)
+			}
+		}
+		return insertBeforeOmz(lines, "ZSH_THEME="+value)
+	})
+}
+
+// repoName returns the name of a git repository from its URL
+func repoName(repo string) string {
+	return strings.TrimSuffix(path.Base(strings.TrimSuffix(repo, "/")), ".git")
+}
+
+// cloneUserRepo clones repo as the target user unless dir already exists
+func (s *Set) cloneUserRepo(repo string, dir string) (bool, error) {
+	if _, err := os.Stat(s.path(dir)); err == nil {
+		return false, nil
+	}
+	err := s.mkdirUser(filepath.Dir(dir))
+	if err != nil {
+		return false, err
+	}
+	out, err := s.userCmd("git", "clone", "--depth=1", repo, dir).CombinedOutput()
+	if err != nil {
+		return false, errors.New(strings.TrimSpace(string(out)))
+	}
+	return true, nil
+}
+
+func execInstallZshPlugin(s *Set, param ...string) (string, error) {
+	repo := param[0]
+	opts := parseOpts(param[1:])
+
+	name := opts.get("name", repoName(repo))
+	_, custom := s.ohMyZsh(opts)
+	cloned, err := s.cloneUserRepo(repo, custom+"/plugins/"+name)
+	if err != nil {
+		return "", err
+	}
+	out := editReport(cloned, "installed %s", name)
+	if opts.has("enable") {
+		enabled, err := s.setZshPlugins([]string{name}, true)
+		if err != nil {
+			return out, err
+		}
+		out += "\n" + editReport(enabled, "enabled %s", name)
+	}
+	return out, nil
+}
+
+func execEnableZshPlugins(s *Set, param ...string) (string, error) {
+	names := strings.Fields(param[0])
+
+	changed, err := s.setZshPlugins(names, true)
+	return editReport(changed, "enabled %s", strings.Join(names, " ")), err
+}
+
+func execDisableZshPlugins(s *Set, param ...string) (string, error) {
+	names := strings.Fields(param[0])
+
+	changed, err := s.setZshPlugins(names, false)
+	return editReport(changed, "disabled %s", strings.Join(names, " ")), err
+}
+
+func execSetZshTheme(s *Set, param ...string) (string, error) {
+	theme := param[0]
+
+	changed, err := s.setZshTheme(theme)
+	return editReport(changed, "set ZSH_THEME to %s", theme), err
+}
+
+// execInstallZshTheme installs a theme into the custom folder, either a
+// .zsh-theme file of the set or a git repository such as powerlevel10k,
+// which oh-my-zsh loads as <dir>/<theme>
+func execInstallZshTheme(s *Set, param ...string) (string, error) {
+	src := param[0]
+	opts := parseOpts(param[1:])
+
+	_, custom := s.ohMyZsh(opts)
+	var theme string
+	var changed bool
+	if strings.HasSuffix(src, ".zsh-theme") {
+		theme = opts.get("name", strings.TrimSuffix(path.Base(src), ".zsh-theme"))
+		data, err := fs.ReadFile(s.files, src)
+		if err != nil {
+			return "", err
+		}
+		err = s.mkdirUser(custom + "/themes")
+		if err != nil {
+			return "", err
+		}
+		changed, err = s.installUserFile(custom+"/themes/"+theme+".zsh-theme", data, 0644, false)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		name := opts.get("name", repoName(src))
+		dir := custom + "/themes/" + name
+		var err error
+		changed, err = s.cloneUserRepo(src, dir)
+		if err != nil {
+			return "", err
+		}
+		file := name + ".zsh-theme"
+		if _, err := os.Stat(s.path(dir + "/" + file)); err != nil {
+			matches, _ := filepath.Glob(s.path(dir + "/*.zsh-theme"))
+			if len(matches) == 0 {
+				return "", fmt.Errorf("no .zsh-theme file in %s", src)
+			}
+			file = filepath.Base(matches[0])
+		}
+		theme = name + "/" + strings.TrimSuffix(file, ".zsh-theme")
+	}
+	out := editReport(changed, "installed theme %s", theme)
+	if opts.has("set") {
+		set, err := s.setZshTheme(theme)
+		if err != nil {
+			return out, err
+		}
+		out += "\n" + editReport(set, "set ZSH_THEME to %s", theme)
+	}
+	return out, nil
+}
@@ -0,0 +1,196 @@
+package run
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func addPlugin(name string) func([]string) []string {
+	return func(plugins []string) []string {
+		if containsStr(plugins, name) {
+			return plugins
+		}
+		return append(plugins, name)
+	}
+}
+
+func TestEditZshPlugins(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			"single line",
+			"plugins=(git)\nsource $ZSH/oh-my-zsh.sh",
+			"plugins=(git z)\nsource $ZSH/oh-my-zsh.sh",
+		},
+		{
+			"already enabled",
+			"plugins=(git z)",
+			"plugins=(git z)",
+		},
+		{
+			"trailing comment",
+			"plugins=(git) # (c)",
+			"plugins=(git z) # (c)",
+		},
+		{
+			"multi line",
+			"plugins=(\n  git\n  docker\n)\nsource $ZSH/oh-my-zsh.sh",
+			"plugins=(\n  git\n  docker\n  z\n)\nsource $ZSH/oh-my-zsh.sh",
+		},
+		{
+			"comments in the array",
+			"plugins=( # bundled\n  git # vcs\n  # docker\n)",
+			"plugins=(\n  git\n  z\n)",
+		},
+		{
+			"commented array",
+			"# plugins=(rails)\nplugins=(git)",
+			"# plugins=(rails)\nplugins=(git z)",
+		},
+		{
+			"missing array",
+			"export ZSH=\"$HOME/.oh-my-zsh\"\nsource $ZSH/oh-my-zsh.sh",
+			"export ZSH=\"$HOME/.oh-my-zsh\"\nplugins=(z)\nsource $ZSH/oh-my-zsh.sh",
+		},
+		{
+			"missing array and oh-my-zsh",
+			"alias ll='ls -l'",
+			"alias ll='ls -l'\nplugins=(z)",
+		},
+	}
+	for _, tt := range tests {
+		got := strings.Join(editZshPlugins(strings.Split(tt.in, "\n"), addPlugin("z")), "\n")
+		if got != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEditZshPluginsRemove(t *testing.T) {
+	remove := func(plugins []string) []string {
+		var result []string
+		for _, p := range plugins {
+			if p != "docker" {
+				result = append(result, p)
+			}
+		}
+		return result
+	}
+	got := strings.Join(editZshPlugins([]string{"plugins=(git docker) # mine"}, remove), "\n")
+	if want := "plugins=(git) # mine"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	got = strings.Join(editZshPlugins([]string{"source $ZSH/oh-my-zsh.sh"}, remove), "\n")
+	if want := "source $ZSH/oh-my-zsh.sh"; got != want {
+		t.Errorf("removing from a missing array gave %q, want %q", got, want)
+	}
+}
+
+func TestInsertBeforeOmz(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"a\nsource $ZSH/oh-my-zsh.sh\nb", "a\nX\nsource $ZSH/oh-my-zsh.sh\nb"},
+		{". \"$ZSH/oh-my-zsh.sh\"", "X\n. \"$ZSH/oh-my-zsh.sh\""},
+		{"# source $ZSH/oh-my-zsh.sh", "# source $ZSH/oh-my-zsh.sh\nX"},
+		{"a", "a\nX"},
+	}
+	for _, tt := range tests {
+		got := strings.Join(insertBeforeOmz(strings.Split(tt.in, "\n"), "X"), "\n")
+		if got != tt.want {
+			t.Errorf("insertBeforeOmz(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// zshSet returns a set working on a root directory holding the .zshrc of
+// the user tester
+func zshSet(t *testing.T, zshrc string) *Set {
+	root := t.TempDir()
+	home := "/home/tester"
+	err := os.MkdirAll(filepath.Join(root, home), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(root, home, ".zshrc"), []byte(zshrc), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Set{Root: root, user: "tester", home: home}
+}
+
+func TestOhMyZsh(t *testing.T) {
+	tests := []struct {
+		zshrc  string
+		zsh    string
+		custom string
+	}{
+		{"", "/home/tester/.oh-my-zsh", "/home/tester/.oh-my-zsh/custom"},
+		{"export ZSH=\"$HOME/.oh-my-zsh\"\nZSH_CUSTOM=$ZSH/custom", "/home/tester/.oh-my-zsh", "/home/tester/.oh-my-zsh/custom"},
+		{"export ZSH=~/.omz\nZSH_CUSTOM=\"${ZSH}/mine\"", "/home/tester/.omz", "/home/tester/.omz/mine"},
+		{"ZSH=/usr/share/oh-my-zsh\nZSH_CUSTOM=~/.zsh-custom", "/usr/share/oh-my-zsh", "/home/tester/.zsh-custom"},
+		{"export ZSH=${HOME}/.oh-my-zsh\nZSH_CUSTOM=$ZSH_CUSTOM_DIR", "/home/tester/.oh-my-zsh", "$ZSH_CUSTOM_DIR"},
+	}
+	for _, tt := range tests {
+		s := zshSet(t, tt.zshrc)
+		zsh, custom := s.ohMyZsh(stepOpts{})
+		if zsh != tt.zsh || custom != tt.custom {
+			t.Errorf("%q gave %s and %s, want %s and %s", tt.zshrc, zsh, custom, tt.zsh, tt.custom)
+		}
+	}
+}
+
+func TestSetZshTheme(t *testing.T) {
+	tests := []struct {
+		zshrc string
+		want  string
+	}{
+		{"ZSH_THEME=\"robbyrussell\"\nsource $ZSH/oh-my-zsh.sh\n", "ZSH_THEME=\"agnoster\"\nsource $ZSH/oh-my-zsh.sh\n"},
+		{"plugins=(git)\nsource $ZSH/oh-my-zsh.sh\n", "plugins=(git)\nZSH_THEME=\"agnoster\"\nsource $ZSH/oh-my-zsh.sh\n"},
+		{"ZSH_THEME=\"agnoster\"\n", "ZSH_THEME=\"agnoster\"\n"},
+	}
+	for _, tt := range tests {
+		s := zshSet(t, tt.zshrc)
+		changed, err := s.setZshTheme("agnoster")
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(s.path(s.home + "/.zshrc"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != tt.want {
+			t.Errorf("%q gave %q, want %q", tt.zshrc, data, tt.want)
+		}
+		if changed != (tt.zshrc != tt.want) {
+			t.Errorf("%q reported changed %v", tt.zshrc, changed)
+		}
+	}
+}
+
+func TestSetZshThemeRefusesLinksOutOfHome(t *testing.T) {
+	s := zshSet(t, "")
+	err := os.WriteFile(filepath.Join(s.Root, "etc-file"), []byte("keep\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zshrc := s.path(s.home + "/.zshrc")
+	_ = os.Remove(zshrc)
+	err = os.Symlink("/etc-file", zshrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.setZshTheme("agnoster"); err == nil {
+		t.Error("edited a .zshrc linking out of the home directory")
+	}
+	data, _ := os.ReadFile(filepath.Join(s.Root, "etc-file"))
+	if string(data) != "keep\n" {
+		t.Errorf("the link target was changed to %q", data)
+	}
+}